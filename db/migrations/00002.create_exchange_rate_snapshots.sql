@@ -0,0 +1,16 @@
+BEGIN;
+
+-- Every rate table the currency stage fetched, so that events can be
+-- converted with the rates that were in effect when they happened.
+CREATE TABLE IF NOT EXISTS exchange_rate_snapshots (
+    id bigserial PRIMARY KEY,
+    source text NOT NULL,
+    base text NOT NULL,
+    as_of timestamptz NOT NULL,
+    fetched_at timestamptz NOT NULL,
+    rates jsonb NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS exchange_rate_snapshots_fetched_at_idx ON exchange_rate_snapshots (fetched_at);
+
+COMMIT;
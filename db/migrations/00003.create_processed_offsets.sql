@@ -0,0 +1,15 @@
+BEGIN;
+
+-- Position of consumer groups whose stages write their output to Postgres.
+-- It is updated in the same transaction as the output, so that a restarted
+-- stage resumes exactly after the last message it wrote.
+CREATE TABLE IF NOT EXISTS processed_offsets (
+    consumer_group text NOT NULL,
+    topic text NOT NULL,
+    partition integer NOT NULL,
+    next_offset bigint NOT NULL,
+    updated_at timestamptz NOT NULL DEFAULT now(),
+    PRIMARY KEY (consumer_group, topic, partition)
+);
+
+COMMIT;
@@ -0,0 +1,17 @@
+BEGIN;
+
+-- Every event the player stage enriched, written together with its offset in
+-- processed_offsets.
+CREATE TABLE IF NOT EXISTS player_events (
+    id bigserial PRIMARY KEY,
+    event_id bigint NOT NULL,
+    player_id bigint NOT NULL,
+    type text NOT NULL,
+    email text,
+    created_at timestamptz NOT NULL,
+    processed_at timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS player_events_event_id_idx ON player_events (event_id);
+
+COMMIT;
@@ -6,6 +6,7 @@ require (
 	github.com/go-redis/redis/v8 v8.11.5
 	github.com/segmentio/kafka-go v0.4.47
 	golang.org/x/net v0.17.0
+	google.golang.org/protobuf v1.33.0
 )
 
 require (
@@ -0,0 +1,5 @@
+// Package casinopb holds the code generated from internal/casino/event.proto.
+// Regenerate it with protoc and protoc-gen-go v1.33.0 on the PATH.
+package casinopb
+
+//go:generate protoc -I .. --go_out=../../.. --go_opt=module=github.com/Bitstarz-eng/event-processing-challenge event.proto
@@ -1,9 +1,66 @@
 package casino
 
-var Currencies = []string{
-	"EUR",
-	"USD",
-	"GBP",
-	"NZD",
-	"BTC",
+import (
+	"fmt"
+	"sort"
+
+	"github.com/shopspring/decimal"
+)
+
+// CurrencyRegistry describes the currencies amounts can be in.
+var CurrencyRegistry = map[string]Currency{
+	"EUR": {Code: "EUR", Exponent: 2, Symbol: "€", Precision: 2},
+	"USD": {Code: "USD", Exponent: 2, Symbol: "$", Precision: 2},
+	"GBP": {Code: "GBP", Exponent: 2, Symbol: "£", Precision: 2},
+	"NZD": {Code: "NZD", Exponent: 2, Symbol: "NZ$", Precision: 2},
+	"BTC": {Code: "BTC", Exponent: 8, Symbol: "₿", Precision: 8},
+}
+
+// Currencies lists the codes of the registry in alphabetical order.
+var Currencies = currencyCodes()
+
+type Currency struct {
+	// ISO 4217 code, or the usual ticker for crypto currencies.
+	Code string
+	// Number of digits after the decimal point of the smallest unit, 2 for
+	// cents and 8 for satoshis.
+	Exponent int32
+	Symbol   string
+	// Number of digits after the decimal point amounts are displayed with.
+	Precision int32
+}
+
+func currencyCodes() []string {
+	codes := make([]string, 0, len(CurrencyRegistry))
+	for code := range CurrencyRegistry {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+
+	return codes
+}
+
+func LookupCurrency(code string) (Currency, error) {
+	currency, ok := CurrencyRegistry[code]
+	if !ok {
+		return Currency{}, fmt.Errorf("unknown currency %q", code)
+	}
+
+	return currency, nil
+}
+
+// Major turns an amount in the smallest unit into whole units.
+func (c Currency) Major(amount int) decimal.Decimal {
+	return decimal.New(int64(amount), -c.Exponent)
+}
+
+// Minor turns an amount in whole units into the smallest unit, rounding to the
+// nearest one.
+func (c Currency) Minor(amount decimal.Decimal) int {
+	return int(amount.Shift(c.Exponent).Round(0).IntPart())
+}
+
+// Format displays an amount in the smallest unit, like "0.00012345 BTC".
+func (c Currency) Format(amount int) string {
+	return c.Major(amount).StringFixed(c.Precision) + " " + c.Code
 }
@@ -3,8 +3,13 @@ package casino
 import (
 	"encoding/json"
 	"time"
+
+	"github.com/shopspring/decimal"
 )
 
+// EventSchemaVersion is bumped whenever the serialized form of Event changes.
+const EventSchemaVersion = "2"
+
 var EventTypes = []string{
 	"game_start",
 	"bet",
@@ -34,11 +39,26 @@ type Event struct {
 
 	CreatedAt time.Time `json:"created_at"`
 
-	AmountEUR   int    `json:"amount_eur,omitempty"`
+	AmountEUR int `json:"amount_eur,omitempty"`
+	// The rate AmountEUR was converted with, unless Currency is EUR.
+	ExchangeRate *ExchangeRate `json:"exchange_rate,omitempty"`
+
 	Player      Player `json:"player,omitempty"`
 	Description string `json:"description"`
 }
 
+type ExchangeRate struct {
+	// Units of EUR one unit of Currency is worth.
+	Rate decimal.Decimal `json:"rate"`
+	// The provider the rate came from.
+	Source string `json:"source"`
+	// When the provider published the rate.
+	AsOf time.Time `json:"as_of"`
+	// Set when the rate was past its time to live because no newer one could
+	// be fetched.
+	Stale bool `json:"stale,omitempty"`
+}
+
 func (e *Event) MarshalJSON() ([]byte, error) {
 	type Alias Event
 	return json.Marshal(&struct {
@@ -49,3 +69,9 @@ func (e *Event) MarshalJSON() ([]byte, error) {
 		Alias:     (*Alias)(e),
 	})
 }
+
+// HasAmount reports whether the event moves money, that is whether it has an
+// amount and currency.
+func (e Event) HasAmount() bool {
+	return e.Type == "bet" || e.Type == "deposit"
+}
@@ -1,93 +1,198 @@
 package main
 
 import (
-	"context"
+	"database/sql"
+	"fmt"
 	"log"
 	"net/http"
-	"os"
-	"os/signal"
-	"syscall"
 	"time"
 
-	"github.com/Bitstarz-eng/event-processing-challenge/internal/casino"
 	"github.com/Bitstarz-eng/event-processing-challenge/internal/config"
+	"github.com/Bitstarz-eng/event-processing-challenge/internal/exchange"
 	"github.com/Bitstarz-eng/event-processing-challenge/internal/messaging"
 	"github.com/Bitstarz-eng/event-processing-challenge/internal/process"
+	"github.com/Bitstarz-eng/event-processing-challenge/internal/service"
 
 	"github.com/go-redis/redis/v8"
-	"github.com/segmentio/kafka-go"
+	_ "github.com/lib/pq"
 )
 
 func main() {
 	log.Println("Currency service starting...")
-	ctx, cancel := context.WithCancel(context.Background())
-
-	// Set up signal handling for graceful shutdown
-	signalChan := make(chan os.Signal, 1)
-	signal.Notify(signalChan, os.Interrupt, syscall.SIGTERM)
-
-	// Start a goroutine to handle OS signals
-	go func() {
-		<-signalChan
-		log.Println("Received shutdown signal, stopping service...")
-		cancel()
-	}()
 
 	cfg := config.Initialize(
-		config.WithKafkaBrokers(),
+		config.WithTransport(),
+		config.WithTopics(),
 		config.WithInputTopic(),
+		config.WithConsumerGroup("currency-enricher-group"),
+		config.WithDeadLetterTopic(),
+		config.WithDedup(),
+		config.WithRetry(),
 		config.WithOutputTopic(),
-		config.WithExchangeRateAPIURL(),
+		config.WithWorkers(),
+		config.WithKeyStrategy(),
+		config.WithPublisher(),
+		config.WithExchangeRateProviders(),
 		config.WithExchangeRateCacheDuration(),
-		config.WithExchangeRateAPIKey(),
-		config.WithRedisAddr(),
-		config.WithRedisPassword(),
-		config.WithRedisDB(),
+		config.WithExchangeRateMaxStaleness(),
+		config.WithExchangeRateCache(),
+		config.WithOptionalDbConn(),
+		config.WithShutdownTimeout(),
+		config.WithStatsAddr(),
 	)
 
-	redisClient := redis.NewClient(&redis.Options{
-		Addr:     cfg.RedisAddr,
-		Password: cfg.RedisPassword,
-		DB:       cfg.RedisDB,
-	})
+	svc := service.New("Currency", cfg.ShutdownTimeout)
+	ctx := svc.Context()
+
+	exchangeCfg := cfg.Exchange()
+
+	// Without Redis, rates are cached in process only.
+	var redisClient *redis.Client
+	if exchangeCfg.Cache == "redis" {
+		redisClient = redis.NewClient(&redis.Options{
+			Addr:     cfg.RedisAddr,
+			Password: cfg.RedisPassword,
+			DB:       cfg.RedisDB,
+		})
+		defer redisClient.Close()
+	}
 
 	httpClient := &http.Client{
 		Timeout: 10 * time.Second,
 	}
 
-	reader := kafka.NewReader(kafka.ReaderConfig{
-		Brokers: []string{cfg.KafkaBrokers},
-		Topic:   cfg.InputTopic,
-		GroupID: "currency-enricher-group",
-	})
-	defer reader.Close()
+	transport, err := messaging.NewTransport(cfg.Transport(), cfg.Publish(), cfg.Redis())
+	if err != nil {
+		log.Fatalf("error creating transport: %v", err)
+	}
+
+	topics := messaging.TopicSpecs(cfg.Topics(), messaging.MainTopic, cfg.InputTopic, cfg.OutputTopic)
+	topics = append(topics, messaging.TopicSpecs(cfg.Topics(), messaging.RetryTopic, cfg.RetryTopics...)...)
+	topics = append(topics, messaging.TopicSpecs(cfg.Topics(), messaging.DeadLetterTopic, cfg.DeadLetterTopic)...)
+	err = transport.EnsureTopics(ctx, topics, cfg.TopicCreate)
+	if err != nil {
+		log.Fatalf("error provisioning topics: %v", err)
+	}
+
+	source, err := transport.Source(cfg.InputTopic, cfg.ConsumerGroup)
+	if err != nil {
+		log.Fatalf("error creating source: %v", err)
+	}
+
+	sink, err := transport.Sink(cfg.OutputTopic)
+	if err != nil {
+		log.Fatalf("error creating sink: %v", err)
+	}
+	svc.OnClose(sink)
 
-	writer := kafka.NewWriter(kafka.WriterConfig{
-		Brokers: []string{cfg.KafkaBrokers},
-		Topic:   cfg.OutputTopic,
-		Async:   true,
-	})
-	defer writer.Close()
+	deadLetterSink, err := transport.Sink(cfg.DeadLetterTopic)
+	if err != nil {
+		log.Fatalf("error creating dead-letter sink: %v", err)
+	}
+	svc.OnClose(deadLetterSink)
+	deadLetter := messaging.NewDeadLetter(deadLetterSink, "currency")
+
+	retryCfg := cfg.Retry()
+	retryTiers := make([]messaging.RetryTier, len(retryCfg.Topics))
+	for i, topic := range retryCfg.Topics {
+		retrySink, err := transport.Sink(topic)
+		if err != nil {
+			log.Fatalf("error creating retry sink: %v", err)
+		}
+		svc.OnClose(retrySink)
+		retryTiers[i] = messaging.RetryTier{Delay: retryCfg.Delays[i], Sink: retrySink}
+	}
+	retrier := messaging.NewRetrier(retryTiers, retryCfg.MaxAttempts)
 
-	consumeCh := make(chan casino.Event)
-	publishCh := make(chan casino.Event)
-	defer close(consumeCh)
-	defer close(publishCh)
+	consumeCh := make(chan messaging.Envelope)
+	publishCh := make(chan messaging.Envelope)
 
 	log.Println("Starting message consumer")
-	consumer := messaging.NewConsumer(ctx, reader, consumeCh)
-	go consumer.Consume()
+	consumers := []*messaging.Consumer{
+		messaging.NewConsumer(ctx, source, consumeCh, messaging.WithDeadLetter(deadLetter), messaging.WithRetry(retrier)),
+	}
+
+	log.Println("Starting retry consumers")
+	for i, topic := range retryCfg.Topics {
+		// Each tier gets its own group, so that its offsets are committed
+		// apart from those of the input topic and the other tiers.
+		retrySource, err := transport.Source(topic, fmt.Sprintf("%s-retry-%d", cfg.ConsumerGroup, i))
+		if err != nil {
+			log.Fatalf("error creating retry source: %v", err)
+		}
+		consumers = append(consumers, messaging.NewConsumer(ctx, messaging.NewDelayedSource(retrySource), consumeCh, messaging.WithDeadLetter(deadLetter), messaging.WithRetry(retrier)))
+	}
+	svc.Consume(consumeCh, consumers...)
+
+	seenSet, err := process.NewSeenSet(cfg.Dedup(), cfg.Redis(), "currency")
+	if err != nil {
+		log.Fatalf("error configuring deduplication: %v", err)
+	}
+
+	log.Println("Starting deduplication")
+	dedupCh := make(chan messaging.Envelope)
+	deduplicator := process.NewDeduplicator(ctx, seenSet, cfg.DedupFlag, true)
+	svc.Go(func() { deduplicator.Process(consumeCh, dedupCh) })
+	svc.Report("duplicates", func() interface{} { return deduplicator.Duplicates() })
+
+	rateProvider, err := exchange.NewTableProvider(exchangeCfg, httpClient)
+	if err != nil {
+		log.Fatalf("error configuring exchange rates: %v", err)
+	}
+	log.Printf("Fetching exchange rates from %s", rateProvider.Name())
+
+	// Without Postgres, there is no rate history and events are converted at
+	// live rates.
+	var history *exchange.RateHistory
+	var tableProvider exchange.TableProvider = rateProvider
+	if cfg.DbConnStr != "" {
+		db, err := sql.Open("postgres", cfg.DbConnStr)
+		if err != nil {
+			log.Fatalf("error opening database: %v", err)
+		}
+		defer db.Close()
+
+		history = exchange.NewRateHistory(db)
+		tableProvider = history.Recording(rateProvider)
+	} else {
+		log.Println("PG_CONN_STR is not set, converting events at live rates")
+	}
+
+	liveRates := exchange.NewSnapshotRates(
+		redisClient,
+		tableProvider,
+		time.Duration(exchangeCfg.CacheDuration)*time.Second,
+		exchangeCfg.MaxStaleness,
+		exchangeCfg.CacheSize,
+	)
+	rates := exchange.NewHistoricalRates(history, liveRates)
+	svc.Report("exchange_rates", func() interface{} { return liveRates.CacheStats() })
 
 	log.Println("Starting currency processor")
-	converter := process.NewConverter(ctx, redisClient, httpClient)
-	go converter.Process(cfg.Exchange(), consumeCh, publishCh)
+	converter := process.NewConverter(ctx, rates, process.NewPool(cfg.Workers, cfg.WorkerBuffer))
+	svc.Go(func() { converter.Process(dedupCh, publishCh) })
+
+	keyStrategy, err := messaging.ParseKeyStrategy(cfg.KeyStrategy)
+	if err != nil {
+		log.Fatalf("error configuring publisher: %v", err)
+	}
+
+	codec, err := messaging.ParseCodec(cfg.PublishCodec)
+	if err != nil {
+		log.Fatalf("error configuring publisher: %v", err)
+	}
+
+	cloudEvents, err := messaging.ParseCloudEventsMode(cfg.PublishCloudEvents)
+	if err != nil {
+		log.Fatalf("error configuring publisher: %v", err)
+	}
 
 	log.Println("Starting message publisher")
-	publisher := messaging.NewPublisher(ctx, writer, publishCh)
-	go publisher.Publish()
+	publisher := messaging.NewPublisher(ctx, sink, publishCh, messaging.WithStage("currency"), messaging.WithKeyStrategy(keyStrategy), messaging.WithBatching(cfg.PublishBatchSize, cfg.PublishLinger), messaging.WithCodec(codec), messaging.WithCloudEvents(cloudEvents), messaging.WithDeliveryReports(deduplicator.Delivered))
+	svc.Go(publisher.Publish)
+
+	log.Println("Starting stats server")
+	svc.ServeStats(cfg.StatsAddr)
 
-	// Wait for context cancellation (graceful shutdown)
-	<-ctx.Done()
-	time.Sleep(2 * time.Second)
-	log.Println("Currency service stopped")
+	svc.Run()
 }
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Bitstarz-eng/event-processing-challenge/internal/config"
+	"github.com/Bitstarz-eng/event-processing-challenge/internal/messaging"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// idleTimeout bounds waiting for the next message, both when reinjecting and
+// when listing.
+const idleTimeout = 10 * time.Second
+
+func main() {
+	if len(os.Args) < 2 {
+		log.Fatalf("usage: %s list|reinject", os.Args[0])
+	}
+
+	cfg := config.Initialize(
+		config.WithKafkaBrokers(),
+		config.WithDeadLetterTopic(),
+		config.WithPublisher(),
+	)
+
+	ctx := context.Background()
+
+	var err error
+	switch os.Args[1] {
+	case "list":
+		err = list(ctx, cfg.Kafka())
+	case "reinject":
+		err = reinject(ctx, cfg.Kafka(), cfg.Publish())
+	default:
+		err = fmt.Errorf("unknown command %q", os.Args[1])
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// list prints every message currently on the dead-letter topic without
+// consuming it.
+func list(ctx context.Context, kafkaCfg config.Kafka) error {
+	conn, err := dialBroker(ctx, kafkaCfg.Brokers)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	partitions, err := conn.ReadPartitions(kafkaCfg.DeadLetterTopic)
+	if err != nil {
+		return fmt.Errorf("read partitions: %v", err)
+	}
+
+	for _, partition := range partitions {
+		err := listPartition(ctx, kafkaCfg, partition)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// dialBroker connects to the first of the comma-separated brokers that can be
+// reached.
+func dialBroker(ctx context.Context, brokers string) (*kafka.Conn, error) {
+	var errs []string
+	for _, broker := range strings.Split(brokers, ",") {
+		conn, err := kafka.DialContext(ctx, "tcp", broker)
+		if err == nil {
+			return conn, nil
+		}
+		errs = append(errs, fmt.Sprintf("%s: %v", broker, err))
+	}
+
+	return nil, fmt.Errorf("dial kafka: %s", strings.Join(errs, "; "))
+}
+
+func listPartition(ctx context.Context, kafkaCfg config.Kafka, partition kafka.Partition) error {
+	leaderAddr := net.JoinHostPort(partition.Leader.Host, strconv.Itoa(partition.Leader.Port))
+	leader, err := kafka.DialLeader(ctx, "tcp", leaderAddr, kafkaCfg.DeadLetterTopic, partition.ID)
+	if err != nil {
+		return fmt.Errorf("dial partition leader: %v", err)
+	}
+	first, last, err := leader.ReadOffsets()
+	leader.Close()
+	if err != nil {
+		return fmt.Errorf("read offsets: %v", err)
+	}
+
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:   strings.Split(kafkaCfg.Brokers, ","),
+		Topic:     kafkaCfg.DeadLetterTopic,
+		Partition: partition.ID,
+	})
+	defer reader.Close()
+
+	err = reader.SetOffset(first)
+	if err != nil {
+		return fmt.Errorf("set offset: %v", err)
+	}
+
+	source := messaging.NewKafkaSource(reader)
+	for offset := first; offset < last; {
+		fetchCtx, cancel := context.WithTimeout(ctx, idleTimeout)
+		msg, err := source.FetchMessage(fetchCtx)
+		cancel()
+		if errors.Is(err, context.DeadlineExceeded) {
+			return fmt.Errorf("no message at offset %d of partition %d within %v", offset, partition.ID, idleTimeout)
+		}
+		if err != nil {
+			return fmt.Errorf("fetch message: %v", err)
+		}
+		offset = msg.Offset + 1
+
+		record, err := messaging.ParseDeadLetter(msg)
+		if err != nil {
+			log.Printf("%d/%d: %v", msg.Partition, msg.Offset, err)
+			continue
+		}
+
+		fmt.Printf("%d/%d stage=%s attempts=%d source=%s/%d/%d error=%q\n%s\n",
+			msg.Partition, msg.Offset, record.Stage, record.Attempts,
+			record.Topic, record.Partition, record.Offset, record.Error, msg.Value)
+	}
+
+	return nil
+}
+
+// reinject moves dead-lettered messages back to their source topic, stopping
+// once no new message has arrived for idleTimeout. Messages whose dead-letter
+// headers are missing or corrupt are logged and committed, so that they do not
+// stop later runs.
+func reinject(ctx context.Context, kafkaCfg config.Kafka, publishCfg config.Publish) error {
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers: strings.Split(kafkaCfg.Brokers, ","),
+		Topic:   kafkaCfg.DeadLetterTopic,
+		GroupID: "dead-letter-reinject-group",
+	})
+	source := messaging.NewKafkaSource(reader)
+	defer source.Close()
+
+	writer, err := messaging.NewKafkaWriter(kafkaCfg.Brokers, "", publishCfg)
+	if err != nil {
+		return fmt.Errorf("create writer: %v", err)
+	}
+	sink := messaging.NewKafkaSink(writer)
+	defer sink.Close()
+
+	reinjected, skipped := 0, 0
+	for {
+		fetchCtx, cancel := context.WithTimeout(ctx, idleTimeout)
+		msg, err := source.FetchMessage(fetchCtx)
+		cancel()
+		if errors.Is(err, context.DeadlineExceeded) {
+			log.Printf("Reinjected %d messages, skipped %d", reinjected, skipped)
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("fetch message: %v", err)
+		}
+
+		reinjection, err := messaging.Reinjection(msg)
+		if err != nil {
+			log.Printf("skipping message %d/%d: %v", msg.Partition, msg.Offset, err)
+			skipped++
+		} else {
+			err = sink.WriteMessages(ctx, reinjection)
+			if err != nil {
+				return fmt.Errorf("reinject message %d/%d: %v", msg.Partition, msg.Offset, err)
+			}
+			reinjected++
+		}
+
+		err = source.CommitMessages(ctx, msg)
+		if err != nil {
+			return fmt.Errorf("commit message %d/%d: %v", msg.Partition, msg.Offset, err)
+		}
+	}
+}
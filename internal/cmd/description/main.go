@@ -1,75 +1,108 @@
 package main
 
 import (
-	"context"
 	"log"
-	"os"
-	"os/signal"
-	"syscall"
-	"time"
 
-	"github.com/Bitstarz-eng/event-processing-challenge/internal/casino"
 	"github.com/Bitstarz-eng/event-processing-challenge/internal/config"
 	"github.com/Bitstarz-eng/event-processing-challenge/internal/messaging"
 	"github.com/Bitstarz-eng/event-processing-challenge/internal/process"
-
-	"github.com/segmentio/kafka-go"
+	"github.com/Bitstarz-eng/event-processing-challenge/internal/service"
 )
 
 func main() {
 	log.Println("Human-friendly description service starting...")
-	ctx, cancel := context.WithCancel(context.Background())
-
-	// Set up signal handling for graceful shutdown
-	signalChan := make(chan os.Signal, 1)
-	signal.Notify(signalChan, os.Interrupt, syscall.SIGTERM)
-
-	// Start a goroutine to handle OS signals
-	go func() {
-		<-signalChan
-		log.Println("Received shutdown signal, stopping service...")
-		cancel()
-	}()
 
 	cfg := config.Initialize(
-		config.WithKafkaBrokers(),
+		config.WithTransport(),
+		config.WithTopics(),
 		config.WithInputTopic(),
+		config.WithConsumerGroup("description-enricher-group"),
+		config.WithDeadLetterTopic(),
+		config.WithDedup(),
 		config.WithOutputTopic(),
+		config.WithWorkers(),
+		config.WithKeyStrategy(),
+		config.WithPublisher(),
+		config.WithShutdownTimeout(),
+		config.WithStatsAddr(),
 	)
 
-	reader := kafka.NewReader(kafka.ReaderConfig{
-		Brokers: []string{cfg.KafkaBrokers},
-		Topic:   cfg.InputTopic,
-		GroupID: "description-enricher-group",
-	})
-	defer reader.Close()
-
-	writer := kafka.NewWriter(kafka.WriterConfig{
-		Brokers: []string{cfg.KafkaBrokers},
-		Topic:   cfg.OutputTopic,
-		Async:   true,
-	})
-	defer writer.Close()
-
-	consumeCh := make(chan casino.Event)
-	publishCh := make(chan casino.Event)
-	defer close(consumeCh)
-	defer close(publishCh)
+	svc := service.New("Description", cfg.ShutdownTimeout)
+	ctx := svc.Context()
+
+	transport, err := messaging.NewTransport(cfg.Transport(), cfg.Publish(), cfg.Redis())
+	if err != nil {
+		log.Fatalf("error creating transport: %v", err)
+	}
+
+	topics := messaging.TopicSpecs(cfg.Topics(), messaging.MainTopic, cfg.InputTopic, cfg.OutputTopic)
+	topics = append(topics, messaging.TopicSpecs(cfg.Topics(), messaging.DeadLetterTopic, cfg.DeadLetterTopic)...)
+	err = transport.EnsureTopics(ctx, topics, cfg.TopicCreate)
+	if err != nil {
+		log.Fatalf("error provisioning topics: %v", err)
+	}
+
+	source, err := transport.Source(cfg.InputTopic, cfg.ConsumerGroup)
+	if err != nil {
+		log.Fatalf("error creating source: %v", err)
+	}
+
+	sink, err := transport.Sink(cfg.OutputTopic)
+	if err != nil {
+		log.Fatalf("error creating sink: %v", err)
+	}
+	svc.OnClose(sink)
+
+	deadLetterSink, err := transport.Sink(cfg.DeadLetterTopic)
+	if err != nil {
+		log.Fatalf("error creating dead-letter sink: %v", err)
+	}
+	svc.OnClose(deadLetterSink)
+	deadLetter := messaging.NewDeadLetter(deadLetterSink, "description")
+
+	consumeCh := make(chan messaging.Envelope)
+	publishCh := make(chan messaging.Envelope)
 
 	log.Println("Starting message consumer")
-	consumer := messaging.NewConsumer(ctx, reader, consumeCh)
-	go consumer.Consume()
+	consumer := messaging.NewConsumer(ctx, source, consumeCh, messaging.WithDeadLetter(deadLetter))
+	svc.Consume(consumeCh, consumer)
+
+	seenSet, err := process.NewSeenSet(cfg.Dedup(), cfg.Redis(), "description")
+	if err != nil {
+		log.Fatalf("error configuring deduplication: %v", err)
+	}
+
+	log.Println("Starting deduplication")
+	dedupCh := make(chan messaging.Envelope)
+	deduplicator := process.NewDeduplicator(ctx, seenSet, cfg.DedupFlag, true)
+	svc.Go(func() { deduplicator.Process(consumeCh, dedupCh) })
+	svc.Report("duplicates", func() interface{} { return deduplicator.Duplicates() })
 
 	log.Println("Starting description processor")
-	descriptor := process.NewDescriptor(ctx)
-	go descriptor.Process(consumeCh, publishCh)
+	descriptor := process.NewDescriptor(ctx, process.NewPool(cfg.Workers, cfg.WorkerBuffer))
+	svc.Go(func() { descriptor.Process(dedupCh, publishCh) })
+
+	keyStrategy, err := messaging.ParseKeyStrategy(cfg.KeyStrategy)
+	if err != nil {
+		log.Fatalf("error configuring publisher: %v", err)
+	}
+
+	codec, err := messaging.ParseCodec(cfg.PublishCodec)
+	if err != nil {
+		log.Fatalf("error configuring publisher: %v", err)
+	}
+
+	cloudEvents, err := messaging.ParseCloudEventsMode(cfg.PublishCloudEvents)
+	if err != nil {
+		log.Fatalf("error configuring publisher: %v", err)
+	}
 
 	log.Println("Starting message publisher")
-	publisher := messaging.NewPublisher(ctx, writer, publishCh)
-	go publisher.Publish()
+	publisher := messaging.NewPublisher(ctx, sink, publishCh, messaging.WithStage("description"), messaging.WithKeyStrategy(keyStrategy), messaging.WithBatching(cfg.PublishBatchSize, cfg.PublishLinger), messaging.WithCodec(codec), messaging.WithCloudEvents(cloudEvents), messaging.WithDeliveryReports(deduplicator.Delivered))
+	svc.Go(publisher.Publish)
+
+	log.Println("Starting stats server")
+	svc.ServeStats(cfg.StatsAddr)
 
-	// Wait for context cancellation (graceful shutdown)
-	<-ctx.Done()
-	time.Sleep(2 * time.Second)
-	log.Println("Description service stopped")
+	svc.Run()
 }
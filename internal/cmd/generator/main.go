@@ -7,39 +7,76 @@ import (
 	"github.com/Bitstarz-eng/event-processing-challenge/internal/config"
 	"github.com/Bitstarz-eng/event-processing-challenge/internal/generator"
 	"github.com/Bitstarz-eng/event-processing-challenge/internal/messaging"
+	"github.com/Bitstarz-eng/event-processing-challenge/internal/service"
 
-	kafka "github.com/segmentio/kafka-go"
 	"golang.org/x/net/context"
 )
 
 func main() {
 	log.Println("Generator service starting...")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
 
 	cfg := config.Initialize(
-		config.WithKafkaBrokers(),
+		config.WithTransport(),
+		config.WithTopics(),
 		config.WithOutputTopic(),
+		config.WithKeyStrategy(),
+		config.WithPublisher(),
+		config.WithShutdownTimeout(),
 	)
 
-	log.Println("Creating Kafka writer")
-	writer := kafka.NewWriter(kafka.WriterConfig{
-		Brokers: []string{cfg.KafkaBrokers},
-		Topic:   cfg.OutputTopic,
-		Async:   true,
-	})
-	defer writer.Close()
+	svc := service.New("Generator", cfg.ShutdownTimeout)
+
+	log.Println("Creating sink")
+	transport, err := messaging.NewTransport(cfg.Transport(), cfg.Publish(), cfg.Redis())
+	if err != nil {
+		log.Fatalf("error creating transport: %v", err)
+	}
+
+	topics := messaging.TopicSpecs(cfg.Topics(), messaging.MainTopic, cfg.OutputTopic)
+	err = transport.EnsureTopics(svc.Context(), topics, cfg.TopicCreate)
+	if err != nil {
+		log.Fatalf("error provisioning topics: %v", err)
+	}
+
+	sink, err := transport.Sink(cfg.OutputTopic)
+	if err != nil {
+		log.Fatalf("error creating sink: %v", err)
+	}
+	svc.OnClose(sink)
 
 	log.Println("Starting event generator")
+	ctx, cancel := context.WithTimeout(svc.Stopping(), 5*time.Second)
+	defer cancel()
 	eventCh := generator.Generate(ctx)
 
-	log.Println("Starting message publisher")
-	publisher := messaging.NewPublisher(ctx, writer, eventCh)
-	go publisher.Publish()
+	keyStrategy, err := messaging.ParseKeyStrategy(cfg.KeyStrategy)
+	if err != nil {
+		log.Fatalf("error configuring publisher: %v", err)
+	}
+
+	codec, err := messaging.ParseCodec(cfg.PublishCodec)
+	if err != nil {
+		log.Fatalf("error configuring publisher: %v", err)
+	}
 
-	for event := range eventCh {
-		log.Printf("%#v\n", event)
+	cloudEvents, err := messaging.ParseCloudEventsMode(cfg.PublishCloudEvents)
+	if err != nil {
+		log.Fatalf("error configuring publisher: %v", err)
 	}
 
+	log.Println("Starting message publisher")
+	publishCh := make(chan messaging.Envelope)
+	publisher := messaging.NewPublisher(svc.Context(), sink, publishCh, messaging.WithStage("generator"), messaging.WithKeyStrategy(keyStrategy), messaging.WithBatching(cfg.PublishBatchSize, cfg.PublishLinger), messaging.WithCodec(codec), messaging.WithCloudEvents(cloudEvents))
+	svc.Go(publisher.Publish)
+
+	svc.Go(func() {
+		defer close(publishCh)
+		for event := range eventCh {
+			log.Printf("%#v\n", event)
+			publishCh <- messaging.NewEnvelope(event)
+		}
+	})
+
+	svc.Run()
 	log.Println("Finished")
 }
@@ -1,52 +1,89 @@
 package main
 
 import (
-	"context"
 	"log"
 	"net/http"
 
-	"github.com/Bitstarz-eng/event-processing-challenge/internal/casino"
 	"github.com/Bitstarz-eng/event-processing-challenge/internal/config"
 	"github.com/Bitstarz-eng/event-processing-challenge/internal/messaging"
 	"github.com/Bitstarz-eng/event-processing-challenge/internal/process"
-
-	"github.com/segmentio/kafka-go"
+	"github.com/Bitstarz-eng/event-processing-challenge/internal/service"
 )
 
 func main() {
 	log.Println("Materialization service starting...")
-	ctx := context.Background()
 
 	cfg := config.Initialize(
-		config.WithKafkaBrokers(),
+		config.WithTransport(),
+		config.WithTopics(),
 		config.WithInputTopic(),
+		config.WithConsumerGroup("materialization-service-group"),
+		config.WithDeadLetterTopic(),
+		config.WithDedup(),
+		config.WithPublisher(),
+		config.WithShutdownTimeout(),
+		config.WithStatsPeers(),
 	)
 
-	reader := kafka.NewReader(kafka.ReaderConfig{
-		Brokers: []string{cfg.KafkaBrokers},
-		Topic:   cfg.InputTopic,
-		GroupID: "materialization-service-group",
-	})
-	defer reader.Close()
+	svc := service.New("Materialization", cfg.ShutdownTimeout)
+	ctx := svc.Context()
+
+	transport, err := messaging.NewTransport(cfg.Transport(), cfg.Publish(), cfg.Redis())
+	if err != nil {
+		log.Fatalf("error creating transport: %v", err)
+	}
+
+	topics := messaging.TopicSpecs(cfg.Topics(), messaging.MainTopic, cfg.InputTopic)
+	topics = append(topics, messaging.TopicSpecs(cfg.Topics(), messaging.DeadLetterTopic, cfg.DeadLetterTopic)...)
+	err = transport.EnsureTopics(ctx, topics, cfg.TopicCreate)
+	if err != nil {
+		log.Fatalf("error provisioning topics: %v", err)
+	}
+
+	source, err := transport.Source(cfg.InputTopic, cfg.ConsumerGroup)
+	if err != nil {
+		log.Fatalf("error creating source: %v", err)
+	}
 
-	consumeCh := make(chan casino.Event)
-	logCh := make(chan casino.Event)
-	defer close(consumeCh)
-	defer close(logCh)
+	deadLetterSink, err := transport.Sink(cfg.DeadLetterTopic)
+	if err != nil {
+		log.Fatalf("error creating dead-letter sink: %v", err)
+	}
+	svc.OnClose(deadLetterSink)
+	deadLetter := messaging.NewDeadLetter(deadLetterSink, "materialize")
+
+	consumeCh := make(chan messaging.Envelope)
+	logCh := make(chan messaging.Envelope)
 
 	log.Println("Starting message consumer")
-	consumer := messaging.NewConsumer(ctx, reader, consumeCh)
-	go consumer.Consume()
+	consumer := messaging.NewConsumer(ctx, source, consumeCh, messaging.WithDeadLetter(deadLetter))
+	svc.Consume(consumeCh, consumer)
+
+	seenSet, err := process.NewSeenSet(cfg.Dedup(), cfg.Redis(), "materialize")
+	if err != nil {
+		log.Fatalf("error configuring deduplication: %v", err)
+	}
+
+	log.Println("Starting deduplication")
+	dedupCh := make(chan messaging.Envelope)
+	deduplicator := process.NewDeduplicator(ctx, seenSet, cfg.DedupFlag, false)
+	svc.Go(func() { deduplicator.Process(consumeCh, dedupCh) })
+	svc.Report("duplicates", func() interface{} { return deduplicator.Duplicates() })
 
 	log.Println("Starting metrics processor")
 	metrics := process.NewMetrics()
-	go metrics.Process(consumeCh, logCh)
+	svc.Go(func() { metrics.Process(dedupCh, logCh) })
 
 	log.Println("Starting logging")
 	logger := process.NewLogger()
-	go logger.Process(logCh)
+	svc.Go(func() { logger.Process(logCh) })
 
 	log.Println("Starting HTTP server")
-	http.HandleFunc("/materialized", metrics.GetMetrics)
-	log.Fatal(http.ListenAndServe(":8080", nil))
+	mux := http.NewServeMux()
+	mux.HandleFunc("/materialized", metrics.GetMetrics)
+	mux.HandleFunc("/stats", svc.GetStats)
+	mux.HandleFunc("/stats/pipeline", service.NewPipelineStats(svc, cfg.StatsPeers).GetStats)
+	svc.Serve(&http.Server{Addr: ":8080", Handler: mux})
+
+	svc.Run()
 }
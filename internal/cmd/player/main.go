@@ -1,83 +1,177 @@
 package main
 
 import (
-	"context"
 	"database/sql"
+	"fmt"
 	"log"
-	"os"
-	"os/signal"
-	"syscall"
-	"time"
 
-	"github.com/Bitstarz-eng/event-processing-challenge/internal/casino"
 	"github.com/Bitstarz-eng/event-processing-challenge/internal/config"
 	"github.com/Bitstarz-eng/event-processing-challenge/internal/messaging"
 	"github.com/Bitstarz-eng/event-processing-challenge/internal/process"
+	"github.com/Bitstarz-eng/event-processing-challenge/internal/service"
 
 	_ "github.com/lib/pq"
-	"github.com/segmentio/kafka-go"
 )
 
 func main() {
 	log.Println("Player service starting...")
-	ctx, cancel := context.WithCancel(context.Background())
-
-	// Set up signal handling for graceful shutdown
-	signalChan := make(chan os.Signal, 1)
-	signal.Notify(signalChan, os.Interrupt, syscall.SIGTERM)
-
-	// Start a goroutine to handle OS signals
-	go func() {
-		<-signalChan
-		log.Println("Received shutdown signal, stopping service...")
-		cancel()
-	}()
 
 	cfg := config.Initialize(
-		config.WithKafkaBrokers(),
+		config.WithTransport(),
+		config.WithTopics(),
 		config.WithInputTopic(),
+		config.WithConsumerGroup("player-enrichment-group"),
+		config.WithOffsetStore(),
+		config.WithDeadLetterTopic(),
+		config.WithDedup(),
+		config.WithRetry(),
 		config.WithOutputTopic(),
+		config.WithWorkers(),
+		config.WithKeyStrategy(),
+		config.WithPublisher(),
 		config.WithDbConn(),
+		config.WithShutdownTimeout(),
+		config.WithStatsAddr(),
 	)
 
+	svc := service.New("Player", cfg.ShutdownTimeout)
+	ctx := svc.Context()
+
 	db, err := sql.Open("postgres", cfg.DbConnStr)
 	if err != nil {
 		log.Fatalf("error opening database: %v", err)
 	}
 	defer db.Close()
 
-	reader := kafka.NewReader(kafka.ReaderConfig{
-		Brokers: []string{cfg.KafkaBrokers},
-		GroupID: "player-enrichment-group",
-		Topic:   cfg.InputTopic,
-	})
-	defer reader.Close()
+	transport, err := messaging.NewTransport(cfg.Transport(), cfg.Publish(), cfg.Redis())
+	if err != nil {
+		log.Fatalf("error creating transport: %v", err)
+	}
+
+	topics := messaging.TopicSpecs(cfg.Topics(), messaging.MainTopic, cfg.InputTopic, cfg.OutputTopic)
+	topics = append(topics, messaging.TopicSpecs(cfg.Topics(), messaging.RetryTopic, cfg.RetryTopics...)...)
+	topics = append(topics, messaging.TopicSpecs(cfg.Topics(), messaging.DeadLetterTopic, cfg.DeadLetterTopic)...)
+	err = transport.EnsureTopics(ctx, topics, cfg.TopicCreate)
+	if err != nil {
+		log.Fatalf("error provisioning topics: %v", err)
+	}
+
+	// With offsets in Postgres, every event is written to player_events
+	// together with its offset, and the input is read from the stored offsets
+	// instead of the consumer group's.
+	var txSink *messaging.TransactionalSink
+	var source messaging.Source
+	if cfg.OffsetStore == "postgres" {
+		offsetStore := messaging.NewOffsetStore(db, cfg.ConsumerGroup)
+		offsets, err := offsetStore.Offsets(ctx, cfg.InputTopic)
+		if err != nil {
+			log.Fatalf("error reading stored offsets: %v", err)
+		}
+
+		partitionSource, err := messaging.NewKafkaPartitionSource(ctx, cfg.KafkaBrokers, cfg.InputTopic, offsets)
+		if err != nil {
+			log.Fatalf("error creating source: %v", err)
+		}
 
-	writer := kafka.NewWriter(kafka.WriterConfig{
-		Brokers: []string{cfg.KafkaBrokers},
-		Topic:   cfg.OutputTopic,
-	})
-	defer writer.Close()
+		txSink = messaging.NewTransactionalSink(db, offsetStore, process.WritePlayerEvent)
+		source = txSink.Source(partitionSource)
+	} else {
+		source, err = transport.Source(cfg.InputTopic, cfg.ConsumerGroup)
+		if err != nil {
+			log.Fatalf("error creating source: %v", err)
+		}
+	}
+
+	sink, err := transport.Sink(cfg.OutputTopic)
+	if err != nil {
+		log.Fatalf("error creating sink: %v", err)
+	}
+	svc.OnClose(sink)
+
+	deadLetterSink, err := transport.Sink(cfg.DeadLetterTopic)
+	if err != nil {
+		log.Fatalf("error creating dead-letter sink: %v", err)
+	}
+	svc.OnClose(deadLetterSink)
+	deadLetter := messaging.NewDeadLetter(deadLetterSink, "player")
+
+	retryCfg := cfg.Retry()
+	retryTiers := make([]messaging.RetryTier, len(retryCfg.Topics))
+	for i, topic := range retryCfg.Topics {
+		retrySink, err := transport.Sink(topic)
+		if err != nil {
+			log.Fatalf("error creating retry sink: %v", err)
+		}
+		svc.OnClose(retrySink)
+		retryTiers[i] = messaging.RetryTier{Delay: retryCfg.Delays[i], Sink: retrySink}
+	}
+	retrier := messaging.NewRetrier(retryTiers, retryCfg.MaxAttempts)
 
-	consumeCh := make(chan casino.Event)
-	publishCh := make(chan casino.Event)
-	defer close(consumeCh)
-	defer close(publishCh)
+	consumeCh := make(chan messaging.Envelope)
+	publishCh := make(chan messaging.Envelope)
 
 	log.Println("Starting message consumer")
-	consumer := messaging.NewConsumer(ctx, reader, consumeCh)
-	go consumer.Consume()
+	consumers := []*messaging.Consumer{
+		messaging.NewConsumer(ctx, source, consumeCh, messaging.WithDeadLetter(deadLetter), messaging.WithRetry(retrier)),
+	}
+
+	log.Println("Starting retry consumers")
+	for i, topic := range retryCfg.Topics {
+		// Each tier gets its own group, so that its offsets are committed
+		// apart from those of the input topic and the other tiers.
+		retrySource, err := transport.Source(topic, fmt.Sprintf("%s-retry-%d", cfg.ConsumerGroup, i))
+		if err != nil {
+			log.Fatalf("error creating retry source: %v", err)
+		}
+		if txSink != nil {
+			retrySource = txSink.Source(retrySource)
+		}
+		consumers = append(consumers, messaging.NewConsumer(ctx, messaging.NewDelayedSource(retrySource), consumeCh, messaging.WithDeadLetter(deadLetter), messaging.WithRetry(retrier)))
+	}
+	svc.Consume(consumeCh, consumers...)
+
+	seenSet, err := process.NewSeenSet(cfg.Dedup(), cfg.Redis(), "player")
+	if err != nil {
+		log.Fatalf("error configuring deduplication: %v", err)
+	}
+
+	log.Println("Starting deduplication")
+	dedupCh := make(chan messaging.Envelope)
+	deduplicator := process.NewDeduplicator(ctx, seenSet, cfg.DedupFlag, true)
+	svc.Go(func() { deduplicator.Process(consumeCh, dedupCh) })
+	svc.Report("duplicates", func() interface{} { return deduplicator.Duplicates() })
 
 	log.Println("Starting description processor")
-	player := process.NewPlayerData(ctx, db)
-	go player.Process(consumeCh, publishCh)
+	player := process.NewPlayerData(ctx, db, process.NewPool(cfg.Workers, cfg.WorkerBuffer))
+	if txSink != nil {
+		enrichedCh := make(chan messaging.Envelope)
+		svc.Go(func() { player.Process(dedupCh, enrichedCh) })
+		svc.Go(func() { txSink.Process(enrichedCh, publishCh) })
+	} else {
+		svc.Go(func() { player.Process(dedupCh, publishCh) })
+	}
+
+	keyStrategy, err := messaging.ParseKeyStrategy(cfg.KeyStrategy)
+	if err != nil {
+		log.Fatalf("error configuring publisher: %v", err)
+	}
+
+	codec, err := messaging.ParseCodec(cfg.PublishCodec)
+	if err != nil {
+		log.Fatalf("error configuring publisher: %v", err)
+	}
+
+	cloudEvents, err := messaging.ParseCloudEventsMode(cfg.PublishCloudEvents)
+	if err != nil {
+		log.Fatalf("error configuring publisher: %v", err)
+	}
 
 	log.Println("Starting message publisher")
-	publisher := messaging.NewPublisher(ctx, writer, publishCh)
-	go publisher.Publish()
+	publisher := messaging.NewPublisher(ctx, sink, publishCh, messaging.WithStage("player"), messaging.WithKeyStrategy(keyStrategy), messaging.WithBatching(cfg.PublishBatchSize, cfg.PublishLinger), messaging.WithCodec(codec), messaging.WithCloudEvents(cloudEvents), messaging.WithDeliveryReports(deduplicator.Delivered))
+	svc.Go(publisher.Publish)
+
+	log.Println("Starting stats server")
+	svc.ServeStats(cfg.StatsAddr)
 
-	// Wait for context cancellation (graceful shutdown)
-	<-ctx.Done()
-	time.Sleep(2 * time.Second)
-	log.Println("Player service stopped")
+	svc.Run()
 }
@@ -0,0 +1,453 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/Bitstarz-eng/event-processing-challenge/internal/config"
+	"github.com/Bitstarz-eng/event-processing-challenge/internal/messaging"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// partitionRange is the range of offsets [start, end) replayed from a partition.
+type partitionRange struct {
+	partition int
+	start     int64
+	end       int64
+}
+
+func main() {
+	cfg := config.Initialize(
+		config.WithKafkaBrokers(),
+		config.WithPublisher(),
+		config.WithReplay(),
+	)
+	replayCfg := cfg.Replay()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	client := &kafka.Client{
+		Addr: kafka.TCP(strings.Split(cfg.KafkaBrokers, ",")...),
+	}
+
+	ranges, err := replayRanges(ctx, client, replayCfg)
+	if err != nil {
+		log.Fatalf("error resolving replay range: %v", err)
+	}
+
+	if replayCfg.TargetGroup != "" {
+		err = startGroup(ctx, client, replayCfg, ranges)
+	} else {
+		err = replay(ctx, cfg.KafkaBrokers, cfg.Publish(), replayCfg, ranges)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// replayRanges resolves the configured offsets and times to a range of
+// offsets on every partition of the source topic.
+func replayRanges(ctx context.Context, client *kafka.Client, replayCfg config.Replay) ([]partitionRange, error) {
+	metadata, err := client.Metadata(ctx, &kafka.MetadataRequest{Topics: []string{replayCfg.SourceTopic}})
+	if err != nil {
+		return nil, fmt.Errorf("metadata: %v", err)
+	}
+	if len(metadata.Topics) != 1 || metadata.Topics[0].Error != nil {
+		return nil, fmt.Errorf("topic %s not found", replayCfg.SourceTopic)
+	}
+
+	var partitions []int
+	for _, partition := range metadata.Topics[0].Partitions {
+		partitions = append(partitions, partition.ID)
+	}
+
+	first, err := listOffsets(ctx, client, replayCfg.SourceTopic, partitions, kafka.FirstOffset)
+	if err != nil {
+		return nil, err
+	}
+	last, err := listOffsets(ctx, client, replayCfg.SourceTopic, partitions, kafka.LastOffset)
+	if err != nil {
+		return nil, err
+	}
+
+	ranges := make([]partitionRange, len(partitions))
+	for i, partition := range partitions {
+		ranges[i] = partitionRange{
+			partition: partition,
+			start:     first[partition],
+			end:       last[partition],
+		}
+	}
+
+	if replayCfg.FromOffset >= 0 {
+		for i := range ranges {
+			ranges[i].start = clamp(replayCfg.FromOffset, ranges[i].start, ranges[i].end)
+		}
+	}
+	if replayCfg.ToOffset >= 0 {
+		for i := range ranges {
+			ranges[i].end = clamp(replayCfg.ToOffset, ranges[i].start, ranges[i].end)
+		}
+	}
+
+	if !replayCfg.FromTime.IsZero() {
+		offsets, err := listOffsets(ctx, client, replayCfg.SourceTopic, partitions, replayCfg.FromTime.UnixMilli())
+		if err != nil {
+			return nil, err
+		}
+		for i := range ranges {
+			if offset, ok := offsets[ranges[i].partition]; ok {
+				ranges[i].start = clamp(offset, ranges[i].start, ranges[i].end)
+			} else {
+				ranges[i].start = ranges[i].end
+			}
+		}
+	}
+	if !replayCfg.ToTime.IsZero() {
+		offsets, err := listOffsets(ctx, client, replayCfg.SourceTopic, partitions, replayCfg.ToTime.UnixMilli())
+		if err != nil {
+			return nil, err
+		}
+		for i := range ranges {
+			if offset, ok := offsets[ranges[i].partition]; ok {
+				ranges[i].end = clamp(offset, ranges[i].start, ranges[i].end)
+			}
+		}
+	}
+
+	return ranges, nil
+}
+
+// listOffsets returns the first or last offset of every partition, or for a
+// timestamp in milliseconds the first offset at or after it. Partitions with
+// no message after the timestamp are left out.
+func listOffsets(ctx context.Context, client *kafka.Client, topic string, partitions []int, timestamp int64) (map[int]int64, error) {
+	requests := make([]kafka.OffsetRequest, len(partitions))
+	for i, partition := range partitions {
+		requests[i] = kafka.OffsetRequest{Partition: partition, Timestamp: timestamp}
+	}
+
+	resp, err := client.ListOffsets(ctx, &kafka.ListOffsetsRequest{
+		Topics: map[string][]kafka.OffsetRequest{topic: requests},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list offsets: %v", err)
+	}
+
+	offsets := make(map[int]int64, len(partitions))
+	for _, partition := range resp.Topics[topic] {
+		if partition.Error != nil {
+			return nil, fmt.Errorf("list offsets of partition %d: %v", partition.Partition, partition.Error)
+		}
+
+		switch timestamp {
+		case kafka.FirstOffset:
+			offsets[partition.Partition] = partition.FirstOffset
+		case kafka.LastOffset:
+			offsets[partition.Partition] = partition.LastOffset
+		default:
+			for offset := range partition.Offsets {
+				if offset >= 0 {
+					offsets[partition.Partition] = offset
+				}
+			}
+		}
+	}
+
+	return offsets, nil
+}
+
+func clamp(offset, min, max int64) int64 {
+	if offset < min {
+		return min
+	}
+	if offset > max {
+		return max
+	}
+	return offset
+}
+
+// startGroup commits the start of the range for a consumer group that has not
+// consumed the topic yet, so that its consumers begin there.
+func startGroup(ctx context.Context, client *kafka.Client, replayCfg config.Replay, ranges []partitionRange) error {
+	if len(replayCfg.EventTypes) > 0 || len(replayCfg.PlayerIDs) > 0 || replayCfg.Rate > 0 || replayCfg.ToOffset >= 0 || !replayCfg.ToTime.IsZero() {
+		log.Printf("Filters, rate limits and range ends do not apply when replaying to a consumer group")
+	}
+
+	partitions := make([]int, len(ranges))
+	commits := make([]kafka.OffsetCommit, len(ranges))
+	for i, r := range ranges {
+		partitions[i] = r.partition
+		commits[i] = kafka.OffsetCommit{Partition: r.partition, Offset: r.start}
+	}
+
+	fetched, err := client.OffsetFetch(ctx, &kafka.OffsetFetchRequest{
+		GroupID: replayCfg.TargetGroup,
+		Topics:  map[string][]int{replayCfg.SourceTopic: partitions},
+	})
+	if err != nil {
+		return fmt.Errorf("fetch offsets of group %s: %v", replayCfg.TargetGroup, err)
+	}
+	if fetched.Error != nil {
+		return fmt.Errorf("fetch offsets of group %s: %v", replayCfg.TargetGroup, fetched.Error)
+	}
+	for _, partition := range fetched.Topics[replayCfg.SourceTopic] {
+		if partition.CommittedOffset >= 0 {
+			return fmt.Errorf("group %s has already consumed %s, use a fresh group", replayCfg.TargetGroup, replayCfg.SourceTopic)
+		}
+	}
+
+	resp, err := client.OffsetCommit(ctx, &kafka.OffsetCommitRequest{
+		GroupID:      replayCfg.TargetGroup,
+		GenerationID: -1,
+		Topics:       map[string][]kafka.OffsetCommit{replayCfg.SourceTopic: commits},
+	})
+	if err != nil {
+		return fmt.Errorf("commit offsets of group %s: %v", replayCfg.TargetGroup, err)
+	}
+	for _, partition := range resp.Topics[replayCfg.SourceTopic] {
+		if partition.Error != nil {
+			return fmt.Errorf("commit offset of partition %d: %v", partition.Partition, partition.Error)
+		}
+	}
+
+	for _, r := range ranges {
+		log.Printf("Group %s starts partition %d of %s at offset %d", replayCfg.TargetGroup, r.partition, replayCfg.SourceTopic, r.start)
+	}
+	return nil
+}
+
+// replay republishes the selected messages of every range to the target topic.
+func replay(ctx context.Context, brokers string, publishCfg config.Publish, replayCfg config.Replay, ranges []partitionRange) error {
+	writer, err := messaging.NewKafkaWriter(brokers, replayCfg.TargetTopic, publishCfg)
+	if err != nil {
+		return fmt.Errorf("create writer: %v", err)
+	}
+	sink := messaging.NewKafkaSink(writer)
+	defer sink.Close()
+
+	r := &replayer{
+		replayCfg: replayCfg,
+		sink:      sink,
+		filter:    newFilter(replayCfg),
+		limiter:   newRateLimiter(replayCfg.Rate),
+		batchSize: publishCfg.BatchSize,
+	}
+	for _, partitionRange := range ranges {
+		r.total += partitionRange.end - partitionRange.start
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go r.reportProgress(done)
+
+	for _, partitionRange := range ranges {
+		err := r.replayPartition(ctx, brokers, partitionRange)
+		if err != nil {
+			return err
+		}
+	}
+
+	log.Printf("Replayed %d of %d messages from %s to %s (%d filtered out)",
+		atomic.LoadInt64(&r.replayed), r.total, replayCfg.SourceTopic, replayCfg.TargetTopic, atomic.LoadInt64(&r.skipped))
+	return nil
+}
+
+type replayer struct {
+	replayCfg config.Replay
+	sink      messaging.Sink
+	filter    *filter
+	limiter   *rateLimiter
+	batchSize int
+
+	total    int64
+	replayed int64
+	skipped  int64
+}
+
+func (r *replayer) replayPartition(ctx context.Context, brokers string, partitionRange partitionRange) error {
+	if partitionRange.start >= partitionRange.end {
+		return nil
+	}
+
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers:   strings.Split(brokers, ","),
+		Topic:     r.replayCfg.SourceTopic,
+		Partition: partitionRange.partition,
+	})
+	source := messaging.NewKafkaSource(reader)
+	defer source.Close()
+
+	err := reader.SetOffset(partitionRange.start)
+	if err != nil {
+		return fmt.Errorf("set offset: %v", err)
+	}
+
+	batch := make([]messaging.Message, 0, r.batchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		err := r.sink.WriteMessages(ctx, batch...)
+		if err != nil {
+			return fmt.Errorf("republish messages of partition %d: %v", partitionRange.partition, err)
+		}
+		atomic.AddInt64(&r.replayed, int64(len(batch)))
+		batch = batch[:0]
+		return nil
+	}
+
+	for offset := partitionRange.start; offset < partitionRange.end; {
+		msg, err := source.FetchMessage(ctx)
+		if err != nil {
+			return fmt.Errorf("fetch message: %v", err)
+		}
+		offset = msg.Offset + 1
+		if msg.Offset >= partitionRange.end {
+			break
+		}
+
+		if !r.filter.keep(msg) {
+			atomic.AddInt64(&r.skipped, 1)
+			continue
+		}
+
+		err = r.limiter.wait(ctx)
+		if err != nil {
+			return err
+		}
+
+		headers := msg.Headers.Without(messaging.HeaderReplay)
+		headers.Add(messaging.HeaderReplay, fmt.Sprintf("%s/%d/%d", msg.Topic, msg.Partition, msg.Offset))
+		batch = append(batch, messaging.Message{
+			Key:     msg.Key,
+			Value:   msg.Value,
+			Headers: headers,
+			Time:    msg.Time,
+		})
+		if len(batch) >= r.batchSize {
+			err := flush()
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return flush()
+}
+
+func (r *replayer) reportProgress(done <-chan struct{}) {
+	ticker := time.NewTicker(r.replayCfg.ProgressInterval)
+	defer ticker.Stop()
+
+	start := time.Now()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			replayed := atomic.LoadInt64(&r.replayed)
+			skipped := atomic.LoadInt64(&r.skipped)
+			log.Printf("Replayed %d, filtered out %d of %d messages (%.0f/s)",
+				replayed, skipped, r.total, float64(replayed)/time.Since(start).Seconds())
+		}
+	}
+}
+
+// filter keeps messages of the configured event types and players. Messages
+// that cannot be decoded only pass when nothing is filtered.
+type filter struct {
+	eventTypes map[string]bool
+	playerIDs  map[int]bool
+}
+
+func newFilter(replayCfg config.Replay) *filter {
+	f := &filter{}
+	if len(replayCfg.EventTypes) > 0 {
+		f.eventTypes = make(map[string]bool)
+		for _, eventType := range replayCfg.EventTypes {
+			f.eventTypes[eventType] = true
+		}
+	}
+	if len(replayCfg.PlayerIDs) > 0 {
+		f.playerIDs = make(map[int]bool)
+		for _, playerID := range replayCfg.PlayerIDs {
+			f.playerIDs[playerID] = true
+		}
+	}
+
+	return f
+}
+
+func (f *filter) keep(msg messaging.Message) bool {
+	if f.eventTypes == nil && f.playerIDs == nil {
+		return true
+	}
+
+	event, err := messaging.DecodeEvent(msg)
+	if err != nil {
+		log.Printf("skipping undecodable message %d/%d: %v", msg.Partition, msg.Offset, err)
+		return false
+	}
+
+	if f.eventTypes != nil && !f.eventTypes[event.Type] {
+		return false
+	}
+	if f.playerIDs != nil && !f.playerIDs[event.PlayerID] {
+		return false
+	}
+	return true
+}
+
+// rateLimiter spaces out messages to at most rate per second. A zero rate
+// does not limit.
+type rateLimiter struct {
+	interval time.Duration
+	next     time.Time
+}
+
+func newRateLimiter(rate float64) *rateLimiter {
+	l := &rateLimiter{}
+	if rate > 0 {
+		l.interval = time.Duration(float64(time.Second) / rate)
+	}
+
+	return l
+}
+
+func (l *rateLimiter) wait(ctx context.Context) error {
+	if l.interval == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+
+	wait := l.next.Sub(now)
+	l.next = l.next.Add(l.interval)
+	if wait <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return errors.New("replay interrupted")
+	case <-timer.C:
+		return nil
+	}
+}
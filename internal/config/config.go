@@ -1,18 +1,74 @@
 package config
 
 import (
+	"fmt"
 	"log"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 )
 
 type Config struct {
+	TransportBackend          string
 	KafkaBrokers              string
+	StreamConsumer            string
+	StreamClaimIdle           time.Duration
+	StreamMaxLen              int64
 	InputTopic                string
 	OutputTopic               string
+	DeadLetterTopic           string
+	KeyStrategy               string
+	TopicPartitions           int
+	TopicReplicationFactor    int
+	TopicRetention            time.Duration
+	RetryTopicPartitions      int
+	RetryTopicRetention       time.Duration
+	DeadLetterTopicPartitions int
+	DeadLetterTopicRetention  time.Duration
+	TopicOverrides            map[string]TopicSettings
+	TopicCreate               bool
+	RetryTopics               []string
+	RetryDelays               []time.Duration
+	RetryMaxAttempts          int
+	PublishBatchSize          int
+	PublishLinger             time.Duration
+	PublishCompression        string
+	PublishRequiredAcks       string
+	PublishCodec              string
+	PublishCloudEvents        string
+	DedupBackend              string
+	DedupWindow               time.Duration
+	DedupCapacity             int
+	DedupFlag                 bool
+	Workers                   int
+	WorkerBuffer              int
+	ShutdownTimeout           time.Duration
+	StatsAddr                 string
+	StatsPeers                []string
+	ConsumerGroup             string
+	OffsetStore               string
+	ReplaySourceTopic         string
+	ReplayTargetTopic         string
+	ReplayTargetGroup         string
+	ReplayFromOffset          int64
+	ReplayToOffset            int64
+	ReplayFromTime            time.Time
+	ReplayToTime              time.Time
+	ReplayEventTypes          []string
+	ReplayPlayerIDs           []int
+	ReplayRate                float64
+	ReplayProgressInterval    time.Duration
 	ExchangeRateAPIURL        string
 	ExchangeRateCacheDuration int
 	ExchangeRateAPIKey        string
+	ExchangeRateMaxStaleness  time.Duration
+	ExchangeRateProviders     []string
+	ExchangeRateECBURL        string
+	ExchangeRateStaticFile    string
+	ExchangeRateTimeout       time.Duration
+	ExchangeRateCache         string
+	ExchangeRateCacheSize     int
 	RedisAddr                 string
 	RedisPassword             string
 	RedisDB                   int
@@ -30,6 +86,69 @@ func WithKafkaBrokers() Option {
 	}
 }
 
+// WithTransport reads which messaging backend the service uses. TRANSPORT is
+// kafka (the default), which needs KAFKA_BROKERS, redis, which uses Redis
+// Streams and needs the Redis settings, or memory, which only connects stages
+// running in the same process.
+func WithTransport() Option {
+	return func(cfg *Config) {
+		cfg.TransportBackend = os.Getenv("TRANSPORT")
+		if cfg.TransportBackend == "" {
+			cfg.TransportBackend = "kafka"
+		}
+
+		switch cfg.TransportBackend {
+		case "kafka":
+			WithKafkaBrokers()(cfg)
+		case "redis":
+			WithRedisAddr()(cfg)
+			WithRedisPassword()(cfg)
+			WithRedisDB()(cfg)
+			WithRedisStreams()(cfg)
+		case "memory":
+		default:
+			log.Fatal("Invalid value for TRANSPORT")
+		}
+	}
+}
+
+// WithRedisStreams reads how Redis Streams are consumed. Consumers are named
+// after the host unless REDIS_STREAM_CONSUMER is set, entries left pending by
+// another consumer for REDIS_STREAM_CLAIM_IDLE (default 1m) are taken over, and
+// streams are trimmed to about REDIS_STREAM_MAXLEN entries if it is set.
+// Taking entries over uses XAUTOCLAIM, which needs Redis 6.2 or newer.
+func WithRedisStreams() Option {
+	return func(cfg *Config) {
+		cfg.StreamConsumer = os.Getenv("REDIS_STREAM_CONSUMER")
+		if cfg.StreamConsumer == "" {
+			hostname, err := os.Hostname()
+			if err != nil {
+				log.Fatal("REDIS_STREAM_CONSUMER environment variable is not set")
+			}
+			cfg.StreamConsumer = hostname
+		}
+
+		cfg.StreamClaimIdle = time.Minute
+		claimIdleStr := os.Getenv("REDIS_STREAM_CLAIM_IDLE")
+		if claimIdleStr != "" {
+			claimIdle, err := time.ParseDuration(claimIdleStr)
+			if err != nil {
+				log.Fatal("Invalid value for REDIS_STREAM_CLAIM_IDLE")
+			}
+			cfg.StreamClaimIdle = claimIdle
+		}
+
+		maxLenStr := os.Getenv("REDIS_STREAM_MAXLEN")
+		if maxLenStr != "" {
+			maxLen, err := strconv.ParseInt(maxLenStr, 10, 64)
+			if err != nil {
+				log.Fatal("Invalid value for REDIS_STREAM_MAXLEN")
+			}
+			cfg.StreamMaxLen = maxLen
+		}
+	}
+}
+
 func WithInputTopic() Option {
 	return func(cfg *Config) {
 		cfg.InputTopic = os.Getenv("KAFKA_INPUT_TOPIC")
@@ -48,6 +167,474 @@ func WithOutputTopic() Option {
 	}
 }
 
+func WithDeadLetterTopic() Option {
+	return func(cfg *Config) {
+		cfg.DeadLetterTopic = os.Getenv("KAFKA_DEAD_LETTER_TOPIC")
+		if cfg.DeadLetterTopic == "" {
+			log.Fatal("KAFKA_DEAD_LETTER_TOPIC environment variable is not set")
+		}
+	}
+}
+
+// WithKeyStrategy reads how published events are keyed, defaulting to player_id.
+func WithKeyStrategy() Option {
+	return func(cfg *Config) {
+		cfg.KeyStrategy = os.Getenv("KAFKA_KEY_STRATEGY")
+		if cfg.KeyStrategy == "" {
+			cfg.KeyStrategy = "player_id"
+		}
+	}
+}
+
+// WithTopics reads how the topics of a service are laid out. Main topics have
+// TOPIC_PARTITIONS (default 3) partitions and TOPIC_RETENTION (the broker
+// default unless set). Retry topics have RETRY_TOPIC_PARTITIONS (default 1)
+// and RETRY_TOPIC_RETENTION (default 24h), dead-letter topics
+// DEAD_LETTER_TOPIC_PARTITIONS (default 1) and DEAD_LETTER_TOPIC_RETENTION
+// (default 336h). TOPIC_OVERRIDES sets single topics apart, as in
+// "casino_events:partitions=6,retention=72h;casino_retry_player_10s:retention=1h".
+// All topics have TOPIC_REPLICATION_FACTOR (default 1) replicas. Missing topics
+// are created unless TOPIC_CREATE is false.
+func WithTopics() Option {
+	return func(cfg *Config) {
+		cfg.TopicPartitions, cfg.TopicRetention = topicSettings("TOPIC", 3, 0)
+		cfg.RetryTopicPartitions, cfg.RetryTopicRetention = topicSettings("RETRY_TOPIC", 1, 24*time.Hour)
+		cfg.DeadLetterTopicPartitions, cfg.DeadLetterTopicRetention = topicSettings("DEAD_LETTER_TOPIC", 1, 14*24*time.Hour)
+		cfg.TopicReplicationFactor = 1
+		cfg.TopicCreate = true
+
+		replicationStr := os.Getenv("TOPIC_REPLICATION_FACTOR")
+		if replicationStr != "" {
+			replication, err := strconv.Atoi(replicationStr)
+			if err != nil || replication < 1 {
+				log.Fatal("Invalid value for TOPIC_REPLICATION_FACTOR")
+			}
+			cfg.TopicReplicationFactor = replication
+		}
+
+		overridesStr := os.Getenv("TOPIC_OVERRIDES")
+		if overridesStr != "" {
+			overrides, err := parseTopicOverrides(overridesStr)
+			if err != nil {
+				log.Fatalf("Invalid value for TOPIC_OVERRIDES: %v", err)
+			}
+			cfg.TopicOverrides = overrides
+		}
+
+		createStr := os.Getenv("TOPIC_CREATE")
+		if createStr != "" {
+			create, err := strconv.ParseBool(createStr)
+			if err != nil {
+				log.Fatal("Invalid value for TOPIC_CREATE")
+			}
+			cfg.TopicCreate = create
+		}
+	}
+}
+
+// topicSettings reads prefix_PARTITIONS and prefix_RETENTION.
+func topicSettings(prefix string, defaultPartitions int, defaultRetention time.Duration) (int, time.Duration) {
+	partitions, retention := defaultPartitions, defaultRetention
+
+	partitionsStr := os.Getenv(prefix + "_PARTITIONS")
+	if partitionsStr != "" {
+		var err error
+		partitions, err = strconv.Atoi(partitionsStr)
+		if err != nil || partitions < 1 {
+			log.Fatalf("Invalid value for %s_PARTITIONS", prefix)
+		}
+	}
+
+	retentionStr := os.Getenv(prefix + "_RETENTION")
+	if retentionStr != "" {
+		var err error
+		retention, err = time.ParseDuration(retentionStr)
+		if err != nil || retention < time.Millisecond {
+			log.Fatalf("Invalid value for %s_RETENTION", prefix)
+		}
+	}
+
+	return partitions, retention
+}
+
+// parseTopicOverrides parses semicolon-separated topics, each followed by a
+// colon and comma-separated partitions and retention settings.
+func parseTopicOverrides(overridesStr string) (map[string]TopicSettings, error) {
+	overrides := make(map[string]TopicSettings)
+	for _, overrideStr := range strings.Split(overridesStr, ";") {
+		topic, settingsStr := overrideStr, ""
+		if i := strings.Index(overrideStr, ":"); i >= 0 {
+			topic, settingsStr = overrideStr[:i], overrideStr[i+1:]
+		}
+		topic = strings.TrimSpace(topic)
+		if topic == "" || settingsStr == "" {
+			return nil, fmt.Errorf("no settings for topic %q", topic)
+		}
+
+		var settings TopicSettings
+		for _, settingStr := range strings.Split(settingsStr, ",") {
+			parts := strings.SplitN(strings.TrimSpace(settingStr), "=", 2)
+			if len(parts) != 2 {
+				return nil, fmt.Errorf("setting %q of topic %s", settingStr, topic)
+			}
+
+			switch parts[0] {
+			case "partitions":
+				partitions, err := strconv.Atoi(parts[1])
+				if err != nil || partitions < 1 {
+					return nil, fmt.Errorf("partitions %q of topic %s", parts[1], topic)
+				}
+				settings.Partitions = partitions
+			case "retention":
+				retention, err := time.ParseDuration(parts[1])
+				if err != nil || retention < time.Millisecond {
+					return nil, fmt.Errorf("retention %q of topic %s", parts[1], topic)
+				}
+				settings.Retention = retention
+			default:
+				return nil, fmt.Errorf("unknown setting %q of topic %s", parts[0], topic)
+			}
+		}
+		overrides[topic] = settings
+	}
+
+	return overrides, nil
+}
+
+// WithRetry reads the retry tiers from RETRY_DELAYS, a comma-separated list of
+// durations such as "10s,1m,10m". Each tier is consumed from the topic
+// KAFKA_RETRY_TOPIC_PREFIX + "_" + delay.
+func WithRetry() Option {
+	return func(cfg *Config) {
+		prefix := os.Getenv("KAFKA_RETRY_TOPIC_PREFIX")
+		if prefix == "" {
+			log.Fatal("KAFKA_RETRY_TOPIC_PREFIX environment variable is not set")
+		}
+
+		delaysStr := os.Getenv("RETRY_DELAYS")
+		if delaysStr == "" {
+			log.Fatal("RETRY_DELAYS environment variable is not set")
+		}
+		for _, delayStr := range strings.Split(delaysStr, ",") {
+			delayStr = strings.TrimSpace(delayStr)
+			delay, err := time.ParseDuration(delayStr)
+			if err != nil {
+				log.Fatal("Invalid value for RETRY_DELAYS")
+			}
+			cfg.RetryTopics = append(cfg.RetryTopics, prefix+"_"+delayStr)
+			cfg.RetryDelays = append(cfg.RetryDelays, delay)
+		}
+
+		maxAttemptsStr := os.Getenv("RETRY_MAX_ATTEMPTS")
+		if maxAttemptsStr == "" {
+			log.Fatal("RETRY_MAX_ATTEMPTS environment variable is not set")
+		}
+		maxAttempts, err := strconv.Atoi(maxAttemptsStr)
+		if err != nil {
+			log.Fatal("Invalid value for RETRY_MAX_ATTEMPTS")
+		}
+		cfg.RetryMaxAttempts = maxAttempts
+	}
+}
+
+// WithPublisher reads how published events are batched, compressed and
+// acknowledged, defaulting to batches of 100 events lingering for at most 10ms,
+// no compression and acknowledgement by all in-sync replicas. PUBLISH_CODEC
+// selects how events are encoded, json (the default) or protobuf, and
+// PUBLISH_CLOUDEVENTS wraps them in structured or binary CloudEvents.
+func WithPublisher() Option {
+	return func(cfg *Config) {
+		cfg.PublishBatchSize = 100
+		cfg.PublishLinger = 10 * time.Millisecond
+		cfg.PublishCompression = "none"
+		cfg.PublishRequiredAcks = "all"
+		cfg.PublishCodec = "json"
+
+		batchSizeStr := os.Getenv("PUBLISH_BATCH_SIZE")
+		if batchSizeStr != "" {
+			batchSize, err := strconv.Atoi(batchSizeStr)
+			if err != nil || batchSize < 1 {
+				log.Fatal("Invalid value for PUBLISH_BATCH_SIZE")
+			}
+			cfg.PublishBatchSize = batchSize
+		}
+
+		lingerStr := os.Getenv("PUBLISH_LINGER")
+		if lingerStr != "" {
+			linger, err := time.ParseDuration(lingerStr)
+			if err != nil {
+				log.Fatal("Invalid value for PUBLISH_LINGER")
+			}
+			cfg.PublishLinger = linger
+		}
+
+		compression := os.Getenv("PUBLISH_COMPRESSION")
+		if compression != "" {
+			cfg.PublishCompression = compression
+		}
+
+		requiredAcks := os.Getenv("PUBLISH_REQUIRED_ACKS")
+		if requiredAcks != "" {
+			cfg.PublishRequiredAcks = requiredAcks
+		}
+
+		codec := os.Getenv("PUBLISH_CODEC")
+		if codec != "" {
+			cfg.PublishCodec = codec
+		}
+
+		cfg.PublishCloudEvents = os.Getenv("PUBLISH_CLOUDEVENTS")
+	}
+}
+
+// WithDedup reads how duplicate events are detected. DEDUP_BACKEND is memory
+// (the default) or redis, in which case the Redis settings are read as well.
+// DEDUP_ACTION is drop (the default) or flag.
+func WithDedup() Option {
+	return func(cfg *Config) {
+		cfg.DedupBackend = "memory"
+		cfg.DedupWindow = 10 * time.Minute
+		cfg.DedupCapacity = 100000
+
+		backend := os.Getenv("DEDUP_BACKEND")
+		if backend != "" {
+			cfg.DedupBackend = backend
+		}
+
+		windowStr := os.Getenv("DEDUP_WINDOW")
+		if windowStr != "" {
+			window, err := time.ParseDuration(windowStr)
+			if err != nil {
+				log.Fatal("Invalid value for DEDUP_WINDOW")
+			}
+			cfg.DedupWindow = window
+		}
+
+		capacityStr := os.Getenv("DEDUP_CAPACITY")
+		if capacityStr != "" {
+			capacity, err := strconv.Atoi(capacityStr)
+			if err != nil || capacity < 1 {
+				log.Fatal("Invalid value for DEDUP_CAPACITY")
+			}
+			cfg.DedupCapacity = capacity
+		}
+
+		switch os.Getenv("DEDUP_ACTION") {
+		case "", "drop":
+		case "flag":
+			cfg.DedupFlag = true
+		default:
+			log.Fatal("Invalid value for DEDUP_ACTION")
+		}
+
+		if cfg.DedupBackend == "redis" {
+			WithRedisAddr()(cfg)
+			WithRedisPassword()(cfg)
+			WithRedisDB()(cfg)
+		}
+	}
+}
+
+// WithWorkers reads how many events a stage processes concurrently and how
+// many each worker may queue, defaulting to 4 workers with 16 queued events.
+func WithWorkers() Option {
+	return func(cfg *Config) {
+		cfg.Workers = 4
+		cfg.WorkerBuffer = 16
+
+		workersStr := os.Getenv("PROCESS_WORKERS")
+		if workersStr != "" {
+			workers, err := strconv.Atoi(workersStr)
+			if err != nil || workers < 1 {
+				log.Fatal("Invalid value for PROCESS_WORKERS")
+			}
+			cfg.Workers = workers
+		}
+
+		bufferStr := os.Getenv("PROCESS_WORKER_BUFFER")
+		if bufferStr != "" {
+			buffer, err := strconv.Atoi(bufferStr)
+			if err != nil || buffer < 0 {
+				log.Fatal("Invalid value for PROCESS_WORKER_BUFFER")
+			}
+			cfg.WorkerBuffer = buffer
+		}
+	}
+}
+
+// WithShutdownTimeout reads how long a service may take to drain in-flight
+// events once it is asked to stop, defaulting to 30s.
+func WithShutdownTimeout() Option {
+	return func(cfg *Config) {
+		cfg.ShutdownTimeout = 30 * time.Second
+
+		timeoutStr := os.Getenv("SHUTDOWN_TIMEOUT")
+		if timeoutStr != "" {
+			timeout, err := time.ParseDuration(timeoutStr)
+			if err != nil || timeout <= 0 {
+				log.Fatal("Invalid value for SHUTDOWN_TIMEOUT")
+			}
+			cfg.ShutdownTimeout = timeout
+		}
+	}
+}
+
+// WithStatsAddr reads the address consumer stats are served on, defaulting to
+// :9090.
+func WithStatsAddr() Option {
+	return func(cfg *Config) {
+		cfg.StatsAddr = os.Getenv("STATS_ADDR")
+		if cfg.StatsAddr == "" {
+			cfg.StatsAddr = ":9090"
+		}
+	}
+}
+
+// WithStatsPeers reads the comma-separated stats URLs of the other stages in
+// STATS_PEERS, which are combined into one view of the pipeline.
+func WithStatsPeers() Option {
+	return func(cfg *Config) {
+		peersStr := os.Getenv("STATS_PEERS")
+		if peersStr == "" {
+			return
+		}
+
+		for _, peer := range strings.Split(peersStr, ",") {
+			peer = strings.TrimSpace(peer)
+			if peer != "" {
+				cfg.StatsPeers = append(cfg.StatsPeers, peer)
+			}
+		}
+	}
+}
+
+// WithConsumerGroup reads the consumer group from CONSUMER_GROUP, defaulting
+// to the group the service normally consumes with.
+func WithConsumerGroup(defaultGroup string) Option {
+	return func(cfg *Config) {
+		cfg.ConsumerGroup = os.Getenv("CONSUMER_GROUP")
+		if cfg.ConsumerGroup == "" {
+			cfg.ConsumerGroup = defaultGroup
+		}
+	}
+}
+
+// WithOffsetStore reads where a stage keeps its input offsets. OFFSET_STORE is
+// kafka (the default), committing them for the consumer group, or postgres,
+// storing them in processed_offsets together with the stage's output. Reading
+// from offsets in Postgres needs the kafka transport, and the stage has to run
+// as a single instance.
+func WithOffsetStore() Option {
+	return func(cfg *Config) {
+		cfg.OffsetStore = os.Getenv("OFFSET_STORE")
+		if cfg.OffsetStore == "" {
+			cfg.OffsetStore = "kafka"
+		}
+
+		switch cfg.OffsetStore {
+		case "kafka":
+		case "postgres":
+			if cfg.TransportBackend != "kafka" {
+				log.Fatal("OFFSET_STORE=postgres needs TRANSPORT=kafka")
+			}
+		default:
+			log.Fatal("Invalid value for OFFSET_STORE")
+		}
+	}
+}
+
+// WithReplay reads what to replay: the range of REPLAY_SOURCE_TOPIC between
+// REPLAY_FROM_OFFSET or REPLAY_FROM_TIME and REPLAY_TO_OFFSET or REPLAY_TO_TIME
+// (RFC 3339), by default all of it. Messages are republished to
+// REPLAY_TARGET_TOPIC, keeping only REPLAY_EVENT_TYPES and REPLAY_PLAYER_IDS
+// when set, at up to REPLAY_RATE messages per second. Alternatively,
+// REPLAY_TARGET_GROUP names a fresh consumer group that is set to start at the
+// beginning of the range.
+func WithReplay() Option {
+	return func(cfg *Config) {
+		cfg.ReplaySourceTopic = os.Getenv("REPLAY_SOURCE_TOPIC")
+		if cfg.ReplaySourceTopic == "" {
+			log.Fatal("REPLAY_SOURCE_TOPIC environment variable is not set")
+		}
+
+		cfg.ReplayTargetTopic = os.Getenv("REPLAY_TARGET_TOPIC")
+		cfg.ReplayTargetGroup = os.Getenv("REPLAY_TARGET_GROUP")
+		if (cfg.ReplayTargetTopic == "") == (cfg.ReplayTargetGroup == "") {
+			log.Fatal("Exactly one of REPLAY_TARGET_TOPIC and REPLAY_TARGET_GROUP must be set")
+		}
+
+		cfg.ReplayFromOffset = -1
+		cfg.ReplayToOffset = -1
+		for _, bound := range []struct {
+			offsetEnv string
+			timeEnv   string
+			offset    *int64
+			time      *time.Time
+		}{
+			{"REPLAY_FROM_OFFSET", "REPLAY_FROM_TIME", &cfg.ReplayFromOffset, &cfg.ReplayFromTime},
+			{"REPLAY_TO_OFFSET", "REPLAY_TO_TIME", &cfg.ReplayToOffset, &cfg.ReplayToTime},
+		} {
+			offsetStr := os.Getenv(bound.offsetEnv)
+			timeStr := os.Getenv(bound.timeEnv)
+			if offsetStr != "" && timeStr != "" {
+				log.Fatalf("Only one of %s and %s may be set", bound.offsetEnv, bound.timeEnv)
+			}
+
+			if offsetStr != "" {
+				offset, err := strconv.ParseInt(offsetStr, 10, 64)
+				if err != nil || offset < 0 {
+					log.Fatalf("Invalid value for %s", bound.offsetEnv)
+				}
+				*bound.offset = offset
+			}
+			if timeStr != "" {
+				t, err := time.Parse(time.RFC3339, timeStr)
+				if err != nil {
+					log.Fatalf("Invalid value for %s", bound.timeEnv)
+				}
+				*bound.time = t
+			}
+		}
+
+		eventTypesStr := os.Getenv("REPLAY_EVENT_TYPES")
+		if eventTypesStr != "" {
+			for _, eventType := range strings.Split(eventTypesStr, ",") {
+				cfg.ReplayEventTypes = append(cfg.ReplayEventTypes, strings.TrimSpace(eventType))
+			}
+		}
+
+		playerIDsStr := os.Getenv("REPLAY_PLAYER_IDS")
+		if playerIDsStr != "" {
+			for _, playerIDStr := range strings.Split(playerIDsStr, ",") {
+				playerID, err := strconv.Atoi(strings.TrimSpace(playerIDStr))
+				if err != nil {
+					log.Fatal("Invalid value for REPLAY_PLAYER_IDS")
+				}
+				cfg.ReplayPlayerIDs = append(cfg.ReplayPlayerIDs, playerID)
+			}
+		}
+
+		rateStr := os.Getenv("REPLAY_RATE")
+		if rateStr != "" {
+			rate, err := strconv.ParseFloat(rateStr, 64)
+			if err != nil || rate < 0 {
+				log.Fatal("Invalid value for REPLAY_RATE")
+			}
+			cfg.ReplayRate = rate
+		}
+
+		cfg.ReplayProgressInterval = 5 * time.Second
+		intervalStr := os.Getenv("REPLAY_PROGRESS_INTERVAL")
+		if intervalStr != "" {
+			interval, err := time.ParseDuration(intervalStr)
+			if err != nil || interval <= 0 {
+				log.Fatal("Invalid value for REPLAY_PROGRESS_INTERVAL")
+			}
+			cfg.ReplayProgressInterval = interval
+		}
+	}
+}
+
 func WithExchangeRateAPIURL() Option {
 	return func(cfg *Config) {
 		cfg.ExchangeRateAPIURL = os.Getenv("EXCHANGE_RATE_API_URL")
@@ -80,6 +667,121 @@ func WithExchangeRateAPIKey() Option {
 	}
 }
 
+// WithExchangeRateMaxStaleness reads how long after it was fetched the last
+// rate table may still be used, defaulting to 1h. Like the cache duration it
+// counts from the fetch, so it cannot be shorter than
+// EXCHANGE_RATE_CACHE_DURATION, which has to be read first.
+func WithExchangeRateMaxStaleness() Option {
+	return func(cfg *Config) {
+		cacheDuration := time.Duration(cfg.ExchangeRateCacheDuration) * time.Second
+		cfg.ExchangeRateMaxStaleness = time.Hour
+		if cfg.ExchangeRateMaxStaleness < cacheDuration {
+			cfg.ExchangeRateMaxStaleness = cacheDuration
+		}
+
+		maxStalenessStr := os.Getenv("EXCHANGE_RATE_MAX_STALENESS")
+		if maxStalenessStr != "" {
+			maxStaleness, err := time.ParseDuration(maxStalenessStr)
+			if err != nil || maxStaleness <= 0 {
+				log.Fatal("Invalid value for EXCHANGE_RATE_MAX_STALENESS")
+			}
+			if maxStaleness < cacheDuration {
+				log.Fatal("EXCHANGE_RATE_MAX_STALENESS is shorter than EXCHANGE_RATE_CACHE_DURATION")
+			}
+			cfg.ExchangeRateMaxStaleness = maxStaleness
+		}
+	}
+}
+
+// WithExchangeRateProviders reads the comma-separated providers rates are
+// fetched from in EXCHANGE_RATE_PROVIDERS, tried in order, along with the
+// settings of each. Providers are exchangerate.host, the default, ecb and
+// static.
+func WithExchangeRateProviders() Option {
+	return func(cfg *Config) {
+		cfg.ExchangeRateProviders = []string{"exchangerate.host"}
+
+		providersStr := os.Getenv("EXCHANGE_RATE_PROVIDERS")
+		if providersStr != "" {
+			cfg.ExchangeRateProviders = nil
+			for _, provider := range strings.Split(providersStr, ",") {
+				provider = strings.TrimSpace(provider)
+				if provider != "" {
+					cfg.ExchangeRateProviders = append(cfg.ExchangeRateProviders, provider)
+				}
+			}
+		}
+
+		for _, provider := range cfg.ExchangeRateProviders {
+			switch provider {
+			case "exchangerate.host":
+				WithExchangeRateAPIURL()(cfg)
+				WithExchangeRateAPIKey()(cfg)
+			case "ecb":
+				cfg.ExchangeRateECBURL = os.Getenv("EXCHANGE_RATE_ECB_URL")
+				if cfg.ExchangeRateECBURL == "" {
+					cfg.ExchangeRateECBURL = "https://www.ecb.europa.eu/stats/eurofxref/eurofxref-daily.xml"
+				}
+			case "static":
+				cfg.ExchangeRateStaticFile = os.Getenv("EXCHANGE_RATE_STATIC_FILE")
+				if cfg.ExchangeRateStaticFile == "" {
+					log.Fatal("EXCHANGE_RATE_STATIC_FILE environment variable is not set")
+				}
+			default:
+				log.Fatalf("Invalid value for EXCHANGE_RATE_PROVIDERS: unknown provider %q", provider)
+			}
+		}
+		if len(cfg.ExchangeRateProviders) == 0 {
+			log.Fatal("Invalid value for EXCHANGE_RATE_PROVIDERS")
+		}
+
+		cfg.ExchangeRateTimeout = 5 * time.Second
+		timeoutStr := os.Getenv("EXCHANGE_RATE_TIMEOUT")
+		if timeoutStr != "" {
+			timeout, err := time.ParseDuration(timeoutStr)
+			if err != nil || timeout <= 0 {
+				log.Fatal("Invalid value for EXCHANGE_RATE_TIMEOUT")
+			}
+			cfg.ExchangeRateTimeout = timeout
+		}
+	}
+}
+
+// WithExchangeRateCache reads where rates are cached. EXCHANGE_RATE_CACHE is
+// redis (the default), sharing rates between replicas, in which case the Redis
+// settings are read as well, or memory for running without Redis.
+// EXCHANGE_RATE_CACHE_SIZE bounds the pairs of currencies kept in process,
+// defaulting to 1024.
+func WithExchangeRateCache() Option {
+	return func(cfg *Config) {
+		cfg.ExchangeRateCache = "redis"
+		cfg.ExchangeRateCacheSize = 1024
+
+		cache := os.Getenv("EXCHANGE_RATE_CACHE")
+		if cache != "" {
+			cfg.ExchangeRateCache = cache
+		}
+		switch cfg.ExchangeRateCache {
+		case "redis":
+			WithRedisAddr()(cfg)
+			WithRedisPassword()(cfg)
+			WithRedisDB()(cfg)
+		case "memory":
+		default:
+			log.Fatal("Invalid value for EXCHANGE_RATE_CACHE")
+		}
+
+		cacheSizeStr := os.Getenv("EXCHANGE_RATE_CACHE_SIZE")
+		if cacheSizeStr != "" {
+			cacheSize, err := strconv.Atoi(cacheSizeStr)
+			if err != nil || cacheSize < 1 {
+				log.Fatal("Invalid value for EXCHANGE_RATE_CACHE_SIZE")
+			}
+			cfg.ExchangeRateCacheSize = cacheSize
+		}
+	}
+}
+
 func WithRedisAddr() Option {
 	return func(cfg *Config) {
 		cfg.RedisAddr = os.Getenv("REDIS_ADDR")
@@ -122,6 +824,14 @@ func WithDbConn() Option {
 	}
 }
 
+// WithOptionalDbConn reads PG_CONN_STR for services that can run without
+// Postgres, leaving DbConnStr empty when it is not set.
+func WithOptionalDbConn() Option {
+	return func(cfg *Config) {
+		cfg.DbConnStr = os.Getenv("PG_CONN_STR")
+	}
+}
+
 func Initialize(options ...Option) *Config {
 	cfg := &Config{}
 	for _, option := range options {
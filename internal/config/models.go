@@ -1,15 +1,92 @@
 package config
 
+import "time"
+
+type Transport struct {
+	Backend         string
+	Brokers         string
+	StreamConsumer  string
+	StreamClaimIdle time.Duration
+	StreamMaxLen    int64
+}
+
 type Kafka struct {
-	Brokers     string
-	InputTopic  string
-	OutputTopic string
+	Brokers         string
+	InputTopic      string
+	OutputTopic     string
+	DeadLetterTopic string
+	KeyStrategy     string
+}
+
+type Publish struct {
+	BatchSize    int
+	Linger       time.Duration
+	Compression  string
+	RequiredAcks string
+	Codec        string
+	CloudEvents  string
+}
+
+type Dedup struct {
+	Backend  string
+	Window   time.Duration
+	Capacity int
+	Flag     bool
+}
+
+// TopicSettings lays out a topic. Zero values are left to the settings of the
+// topic's kind, or to the broker for retention.
+type TopicSettings struct {
+	Partitions int
+	Retention  time.Duration
+}
+
+// Topics lays out the main topics of the pipeline, the retry and dead-letter
+// topics, and any topic set apart from its kind in Overrides.
+type Topics struct {
+	Main              TopicSettings
+	Retry             TopicSettings
+	DeadLetter        TopicSettings
+	Overrides         map[string]TopicSettings
+	ReplicationFactor int
+	Create            bool
+}
+
+type Retry struct {
+	Topics      []string
+	Delays      []time.Duration
+	MaxAttempts int
+}
+
+// Replay selects a range of a topic and where to replay it to. Unset offsets
+// are -1 and unset times are zero.
+type Replay struct {
+	SourceTopic      string
+	TargetTopic      string
+	TargetGroup      string
+	FromOffset       int64
+	ToOffset         int64
+	FromTime         time.Time
+	ToTime           time.Time
+	EventTypes       []string
+	PlayerIDs        []int
+	Rate             float64
+	ProgressInterval time.Duration
 }
 
 type Exchange struct {
 	APIURL        string
 	CacheDuration int
 	APIKey        string
+	MaxStaleness  time.Duration
+	Providers     []string
+	ECBURL        string
+	StaticFile    string
+	// Timeout bounds each provider before falling back to the next.
+	Timeout time.Duration
+	// Cache is redis or memory.
+	Cache     string
+	CacheSize int
 }
 
 type Redis struct {
@@ -18,11 +95,78 @@ type Redis struct {
 	DB       int
 }
 
+func (cfg *Config) Transport() Transport {
+	return Transport{
+		Backend:         cfg.TransportBackend,
+		Brokers:         cfg.KafkaBrokers,
+		StreamConsumer:  cfg.StreamConsumer,
+		StreamClaimIdle: cfg.StreamClaimIdle,
+		StreamMaxLen:    cfg.StreamMaxLen,
+	}
+}
+
 func (cfg *Config) Kafka() Kafka {
 	return Kafka{
-		Brokers:     cfg.KafkaBrokers,
-		InputTopic:  cfg.InputTopic,
-		OutputTopic: cfg.OutputTopic,
+		Brokers:         cfg.KafkaBrokers,
+		InputTopic:      cfg.InputTopic,
+		OutputTopic:     cfg.OutputTopic,
+		DeadLetterTopic: cfg.DeadLetterTopic,
+		KeyStrategy:     cfg.KeyStrategy,
+	}
+}
+
+func (cfg *Config) Publish() Publish {
+	return Publish{
+		BatchSize:    cfg.PublishBatchSize,
+		Linger:       cfg.PublishLinger,
+		Compression:  cfg.PublishCompression,
+		RequiredAcks: cfg.PublishRequiredAcks,
+		Codec:        cfg.PublishCodec,
+		CloudEvents:  cfg.PublishCloudEvents,
+	}
+}
+
+func (cfg *Config) Dedup() Dedup {
+	return Dedup{
+		Backend:  cfg.DedupBackend,
+		Window:   cfg.DedupWindow,
+		Capacity: cfg.DedupCapacity,
+		Flag:     cfg.DedupFlag,
+	}
+}
+
+func (cfg *Config) Topics() Topics {
+	return Topics{
+		Main:              TopicSettings{Partitions: cfg.TopicPartitions, Retention: cfg.TopicRetention},
+		Retry:             TopicSettings{Partitions: cfg.RetryTopicPartitions, Retention: cfg.RetryTopicRetention},
+		DeadLetter:        TopicSettings{Partitions: cfg.DeadLetterTopicPartitions, Retention: cfg.DeadLetterTopicRetention},
+		Overrides:         cfg.TopicOverrides,
+		ReplicationFactor: cfg.TopicReplicationFactor,
+		Create:            cfg.TopicCreate,
+	}
+}
+
+func (cfg *Config) Replay() Replay {
+	return Replay{
+		SourceTopic:      cfg.ReplaySourceTopic,
+		TargetTopic:      cfg.ReplayTargetTopic,
+		TargetGroup:      cfg.ReplayTargetGroup,
+		FromOffset:       cfg.ReplayFromOffset,
+		ToOffset:         cfg.ReplayToOffset,
+		FromTime:         cfg.ReplayFromTime,
+		ToTime:           cfg.ReplayToTime,
+		EventTypes:       cfg.ReplayEventTypes,
+		PlayerIDs:        cfg.ReplayPlayerIDs,
+		Rate:             cfg.ReplayRate,
+		ProgressInterval: cfg.ReplayProgressInterval,
+	}
+}
+
+func (cfg *Config) Retry() Retry {
+	return Retry{
+		Topics:      cfg.RetryTopics,
+		Delays:      cfg.RetryDelays,
+		MaxAttempts: cfg.RetryMaxAttempts,
 	}
 }
 
@@ -31,6 +175,13 @@ func (cfg *Config) Exchange() Exchange {
 		APIURL:        cfg.ExchangeRateAPIURL,
 		CacheDuration: cfg.ExchangeRateCacheDuration,
 		APIKey:        cfg.ExchangeRateAPIKey,
+		MaxStaleness:  cfg.ExchangeRateMaxStaleness,
+		Providers:     cfg.ExchangeRateProviders,
+		ECBURL:        cfg.ExchangeRateECBURL,
+		StaticFile:    cfg.ExchangeRateStaticFile,
+		Timeout:       cfg.ExchangeRateTimeout,
+		Cache:         cfg.ExchangeRateCache,
+		CacheSize:     cfg.ExchangeRateCacheSize,
 	}
 }
 
@@ -0,0 +1,122 @@
+package exchange
+
+import (
+	"container/list"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CacheStats counts how rate lookups were served. Hits, Misses and Expired
+// are lookups of the in-process cache, SharedHits and SharedMisses refreshes
+// served from Redis or not, and Fetches and FetchErrors refreshes from the
+// providers.
+type CacheStats struct {
+	Hits         int64 `json:"hits"`
+	Misses       int64 `json:"misses"`
+	Expired      int64 `json:"expired"`
+	SharedHits   int64 `json:"shared_hits"`
+	SharedMisses int64 `json:"shared_misses"`
+	Fetches      int64 `json:"fetches"`
+	FetchErrors  int64 `json:"fetch_errors"`
+	Entries      int   `json:"entries"`
+}
+
+type cacheCounters struct {
+	hits         int64
+	misses       int64
+	expired      int64
+	sharedHits   int64
+	sharedMisses int64
+	fetches      int64
+	fetchErrors  int64
+}
+
+func (r *SnapshotRates) CacheStats() CacheStats {
+	return CacheStats{
+		Hits:         atomic.LoadInt64(&r.counters.hits),
+		Misses:       atomic.LoadInt64(&r.counters.misses),
+		Expired:      atomic.LoadInt64(&r.counters.expired),
+		SharedHits:   atomic.LoadInt64(&r.counters.sharedHits),
+		SharedMisses: atomic.LoadInt64(&r.counters.sharedMisses),
+		Fetches:      atomic.LoadInt64(&r.counters.fetches),
+		FetchErrors:  atomic.LoadInt64(&r.counters.fetchErrors),
+		Entries:      r.quotes.len(),
+	}
+}
+
+type cacheResult int
+
+const (
+	cacheMiss cacheResult = iota
+	cacheHit
+	cacheExpired
+)
+
+// quoteCache keeps up to capacity quotes until they expire, dropping the least
+// recently used one when full.
+type quoteCache struct {
+	mu       sync.Mutex
+	capacity int
+	entries  map[string]*list.Element
+	order    *list.List
+}
+
+type quoteEntry struct {
+	key     string
+	quote   Quote
+	expires time.Time
+}
+
+func newQuoteCache(capacity int) *quoteCache {
+	return &quoteCache{
+		capacity: capacity,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+func (c *quoteCache) get(key string, now time.Time) (Quote, cacheResult) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		return Quote{}, cacheMiss
+	}
+
+	entry := element.Value.(*quoteEntry)
+	if !now.Before(entry.expires) {
+		c.order.Remove(element)
+		delete(c.entries, key)
+		return Quote{}, cacheExpired
+	}
+
+	c.order.MoveToFront(element)
+	return entry.quote, cacheHit
+}
+
+func (c *quoteCache) put(key string, quote Quote, expires time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.entries[key]; ok {
+		element.Value = &quoteEntry{key: key, quote: quote, expires: expires}
+		c.order.MoveToFront(element)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&quoteEntry{key: key, quote: quote, expires: expires})
+	for c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*quoteEntry).key)
+	}
+}
+
+func (c *quoteCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.order.Len()
+}
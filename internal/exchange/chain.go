@@ -0,0 +1,141 @@
+package exchange
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// Chain fetches the rate table from the first of its providers that succeeds
+// within the timeout. Currencies missing from that table are filled in from
+// the providers after it, so that falling back to a provider without some of
+// the currencies does not leave events in them unconvertible. The table's
+// Source tells which providers it came from.
+type Chain struct {
+	providers  []TableProvider
+	currencies []string
+	timeout    time.Duration
+}
+
+// NewChain creates a chain that fills in rates for the given currencies.
+func NewChain(timeout time.Duration, currencies []string, providers ...TableProvider) *Chain {
+	return &Chain{
+		providers:  providers,
+		currencies: currencies,
+		timeout:    timeout,
+	}
+}
+
+func (c *Chain) Name() string {
+	names := make([]string, len(c.providers))
+	for i, provider := range c.providers {
+		names[i] = provider.Name()
+	}
+
+	return strings.Join(names, ",")
+}
+
+func (c *Chain) Table(ctx context.Context) (Table, error) {
+	var failures []string
+	for i, provider := range c.providers {
+		table, err := c.fetch(ctx, provider)
+		if err == nil {
+			if len(failures) > 0 {
+				log.Printf("Fetched exchange rates from fallback provider %s", provider.Name())
+			}
+			return c.fill(ctx, table, c.providers[i+1:]), nil
+		}
+
+		log.Printf("error fetching exchange rates from %s: %v", provider.Name(), err)
+		failures = append(failures, fmt.Sprintf("%s: %v", provider.Name(), err))
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	return Table{}, fmt.Errorf("all exchange rate providers failed: %s", strings.Join(failures, "; "))
+}
+
+// fill adds the rates of currencies missing from the table from the first of
+// the providers that has them. The table is then as old as the oldest of the
+// tables it was filled from.
+func (c *Chain) fill(ctx context.Context, table Table, providers []TableProvider) Table {
+	missing := c.missing(table)
+	if len(missing) == 0 {
+		return table
+	}
+
+	filled := Table{
+		Base:   table.Base,
+		Source: table.Source,
+		AsOf:   table.AsOf,
+		Rates:  make(map[string]decimal.Decimal, len(table.Rates)+len(missing)),
+	}
+	for currency, rate := range table.Rates {
+		filled.Rates[currency] = rate
+	}
+
+	for _, provider := range providers {
+		if len(missing) == 0 || ctx.Err() != nil {
+			break
+		}
+
+		other, err := c.fetch(ctx, provider)
+		if err != nil {
+			log.Printf("error fetching exchange rates from %s: %v", provider.Name(), err)
+			continue
+		}
+		other, err = other.Rebase(table.Base)
+		if err != nil {
+			log.Printf("error filling in exchange rates from %s: %v", provider.Name(), err)
+			continue
+		}
+
+		var added []string
+		for _, currency := range missing {
+			rate, ok := other.Rates[currency]
+			if !ok {
+				continue
+			}
+			filled.Rates[currency] = rate
+			added = append(added, currency)
+		}
+		if len(added) == 0 {
+			continue
+		}
+
+		log.Printf("Filled in exchange rates for %s from %s", strings.Join(added, ","), provider.Name())
+		filled.Source += "+" + other.Source
+		if !other.AsOf.IsZero() && other.AsOf.Before(filled.AsOf) {
+			filled.AsOf = other.AsOf
+		}
+		missing = c.missing(filled)
+	}
+
+	if len(missing) > 0 {
+		log.Printf("No exchange rates for %s", strings.Join(missing, ","))
+	}
+	return filled
+}
+
+func (c *Chain) missing(table Table) []string {
+	var missing []string
+	for _, currency := range c.currencies {
+		if _, ok := table.Rates[currency]; !ok {
+			missing = append(missing, currency)
+		}
+	}
+
+	return missing
+}
+
+func (c *Chain) fetch(ctx context.Context, provider TableProvider) (Table, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	return provider.Table(ctx)
+}
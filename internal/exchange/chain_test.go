@@ -0,0 +1,116 @@
+package exchange
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+type fixedTable struct {
+	table Table
+	err   error
+}
+
+func (p fixedTable) Name() string {
+	return p.table.Source
+}
+
+func (p fixedTable) Table(ctx context.Context) (Table, error) {
+	return p.table, p.err
+}
+
+func TestChainTable(t *testing.T) {
+	asOf := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	rates := func(pairs ...string) map[string]decimal.Decimal {
+		m := make(map[string]decimal.Decimal)
+		for i := 0; i < len(pairs); i += 2 {
+			m[pairs[i]] = decimal.RequireFromString(pairs[i+1])
+		}
+		return m
+	}
+
+	down := fixedTable{table: Table{Source: "api"}, err: errors.New("unavailable")}
+	ecb := fixedTable{table: Table{Base: "EUR", Source: "ecb", AsOf: asOf, Rates: rates("EUR", "1", "USD", "1.1", "GBP", "0.8")}}
+	static := fixedTable{table: Table{Base: "USD", Source: "static", AsOf: asOf.AddDate(0, -1, 0), Rates: rates("USD", "1", "EUR", "0.5", "GBP", "0.4", "BTC", "0.00002")}}
+
+	tests := []struct {
+		name       string
+		providers  []TableProvider
+		wantSource string
+		wantAsOf   time.Time
+		wantBTC    string
+	}{
+		{
+			name:       "complete table",
+			providers:  []TableProvider{static, ecb},
+			wantSource: "static",
+			wantAsOf:   static.table.AsOf,
+			wantBTC:    "0.00004",
+		},
+		{
+			name:       "missing currency filled from later provider",
+			providers:  []TableProvider{down, ecb, static},
+			wantSource: "ecb+static",
+			wantAsOf:   static.table.AsOf,
+			wantBTC:    "0.00004",
+		},
+		{
+			name:       "missing currency left out",
+			providers:  []TableProvider{down, ecb},
+			wantSource: "ecb",
+			wantAsOf:   ecb.table.AsOf,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			chain := NewChain(time.Second, []string{"EUR", "USD", "GBP", "BTC"}, test.providers...)
+			table, err := chain.Table(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if table.Source != test.wantSource {
+				t.Errorf("got source %q, want %q", table.Source, test.wantSource)
+			}
+			if !table.AsOf.Equal(test.wantAsOf) {
+				t.Errorf("got as of %s, want %s", table.AsOf, test.wantAsOf)
+			}
+
+			rate, err := table.Rate("EUR", "BTC")
+			if test.wantBTC == "" {
+				if err == nil {
+					t.Errorf("got BTC rate %s, want none", rate)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !rate.Equal(decimal.RequireFromString(test.wantBTC)) {
+				t.Errorf("got BTC rate %s, want %s", rate, test.wantBTC)
+			}
+		})
+	}
+
+	if len(ecb.table.Rates) != 3 {
+		t.Errorf("filling in changed the rates of the provider's table")
+	}
+}
+
+func TestStaticFileWithoutRates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rates.json")
+	err := os.WriteFile(path, []byte(`{"base":"EUR"}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = NewStaticFile(path).Table(context.Background())
+	if err == nil {
+		t.Error("got no error for rates file without rates")
+	}
+}
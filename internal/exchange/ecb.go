@@ -0,0 +1,90 @@
+package exchange
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// ECBSource names the ECB reference rates on quotes.
+const ECBSource = "ecb"
+
+// ECB fetches the daily euro reference rates the European Central Bank
+// publishes as XML. They cover fiat currencies only.
+type ECB struct {
+	httpClient *http.Client
+	url        string
+}
+
+func NewECB(httpClient *http.Client, url string) *ECB {
+	return &ECB{
+		httpClient: httpClient,
+		url:        url,
+	}
+}
+
+func (p *ECB) Name() string {
+	return ECBSource
+}
+
+func (p *ECB) Table(ctx context.Context) (Table, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.url, nil)
+	if err != nil {
+		return Table{}, fmt.Errorf("create ECB rates request: %v", err)
+	}
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return Table{}, fmt.Errorf("fetch ECB rates: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return Table{}, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	// <Cube><Cube time="2024-01-02"><Cube currency="USD" rate="1.0956"/>...
+	var result struct {
+		Days []struct {
+			Time  string `xml:"time,attr"`
+			Rates []struct {
+				Currency string `xml:"currency,attr"`
+				Rate     string `xml:"rate,attr"`
+			} `xml:"Cube"`
+		} `xml:"Cube>Cube"`
+	}
+	err = xml.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		return Table{}, fmt.Errorf("decode ECB rates: %v", err)
+	}
+	if len(result.Days) == 0 {
+		return Table{}, fmt.Errorf("invalid ECB rates, no reference day")
+	}
+
+	// The daily file holds one day, history files start with the latest.
+	day := result.Days[0]
+	asOf, err := time.Parse("2006-01-02", day.Time)
+	if err != nil {
+		return Table{}, fmt.Errorf("invalid ECB reference day %q", day.Time)
+	}
+
+	table := Table{
+		Base:   "EUR",
+		Source: ECBSource,
+		AsOf:   asOf,
+		Rates:  map[string]decimal.Decimal{"EUR": decimal.NewFromInt(1)},
+	}
+	for _, rate := range day.Rates {
+		value, err := decimal.NewFromString(rate.Rate)
+		if err != nil || !value.IsPositive() {
+			return Table{}, fmt.Errorf("invalid ECB rate %q for %s", rate.Rate, rate.Currency)
+		}
+		table.Rates[rate.Currency] = value
+	}
+
+	return table, nil
+}
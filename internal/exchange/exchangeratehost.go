@@ -0,0 +1,109 @@
+package exchange
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// ExchangeRateHostSource names the exchangerate.host API on quotes.
+const ExchangeRateHostSource = "exchangerate.host"
+
+// ExchangeRateHost fetches live rates from the exchangerate.host API.
+type ExchangeRateHost struct {
+	httpClient *http.Client
+	apiURL     string
+	apiKey     string
+}
+
+func NewExchangeRateHost(httpClient *http.Client, apiURL, apiKey string) *ExchangeRateHost {
+	return &ExchangeRateHost{
+		httpClient: httpClient,
+		apiURL:     apiURL,
+		apiKey:     apiKey,
+	}
+}
+
+func (p *ExchangeRateHost) Name() string {
+	return ExchangeRateHostSource
+}
+
+func (p *ExchangeRateHost) Rate(ctx context.Context, from, to string) (Quote, error) {
+	table, err := p.Table(ctx)
+	if err != nil {
+		return Quote{}, err
+	}
+
+	quote, err := table.Quote(from, to)
+	if err != nil {
+		return Quote{}, err
+	}
+
+	log.Printf("Fetched exchange rate from API: 1 %s = %s %s", from, quote.Rate, to)
+	return quote, nil
+}
+
+// Table fetches the quotes of all currencies, relative to the API's source
+// currency, USD unless the URL says otherwise.
+func (p *ExchangeRateHost) Table(ctx context.Context) (Table, error) {
+	query := url.Values{}
+	query.Set("access_key", p.apiKey)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.apiURL+"?"+query.Encode(), nil)
+	if err != nil {
+		return Table{}, fmt.Errorf("create exchange rate request: %v", err)
+	}
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return Table{}, fmt.Errorf("fetch exchange rates: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return Table{}, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Success   bool                       `json:"success"`
+		Timestamp int64                      `json:"timestamp"`
+		Source    string                     `json:"source"`
+		Quotes    map[string]decimal.Decimal `json:"quotes"`
+		Error     *struct {
+			Code int    `json:"code"`
+			Info string `json:"info"`
+		} `json:"error"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		return Table{}, fmt.Errorf("decode exchange rate response: %v", err)
+	}
+	if !result.Success {
+		if result.Error != nil {
+			return Table{}, fmt.Errorf("exchange rate API error %d: %s", result.Error.Code, result.Error.Info)
+		}
+		return Table{}, fmt.Errorf("exchange rate API reported failure")
+	}
+	if result.Source == "" {
+		return Table{}, fmt.Errorf("invalid response format, missing source currency")
+	}
+
+	table, err := NewTable(result.Source, result.Quotes)
+	if err != nil {
+		return Table{}, fmt.Errorf("invalid response format: %v", err)
+	}
+
+	table.Source = ExchangeRateHostSource
+	table.AsOf = time.Now().UTC()
+	if result.Timestamp > 0 {
+		table.AsOf = time.Unix(result.Timestamp, 0).UTC()
+	}
+
+	return table, nil
+}
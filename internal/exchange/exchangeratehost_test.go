@@ -0,0 +1,83 @@
+package exchange
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestExchangeRateHostRate(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		from    string
+		want    string
+		wantErr string
+	}{
+		{
+			name:   "success",
+			status: http.StatusOK,
+			body:   `{"success":true,"timestamp":1700000000,"source":"USD","quotes":{"USDEUR":0.92,"USDGBP":0.8}}`,
+			from:   "GBP",
+			want:   "1.15",
+		},
+		{
+			name:    "api error",
+			status:  http.StatusOK,
+			body:    `{"success":false,"error":{"code":101,"info":"invalid access key"}}`,
+			from:    "USD",
+			wantErr: "exchange rate API error 101: invalid access key",
+		},
+		{
+			name:    "missing quote",
+			status:  http.StatusOK,
+			body:    `{"success":true,"source":"USD","quotes":{"USDEUR":0.92}}`,
+			from:    "NZD",
+			wantErr: "no rate for NZD",
+		},
+		{
+			name:    "unexpected status",
+			status:  http.StatusInternalServerError,
+			body:    `{}`,
+			from:    "USD",
+			wantErr: "unexpected status code: 500",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/live" || r.URL.Query().Get("access_key") != "key" {
+					t.Errorf("unexpected request %s", r.URL)
+				}
+				w.WriteHeader(test.status)
+				fmt.Fprint(w, test.body)
+			}))
+			defer server.Close()
+
+			provider := NewExchangeRateHost(server.Client(), server.URL+"/live", "key")
+			quote, err := provider.Rate(context.Background(), test.from, "EUR")
+			if test.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+					t.Fatalf("got error %v, want %q", err, test.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !quote.Rate.Equal(decimal.RequireFromString(test.want)) {
+				t.Errorf("got rate %s, want %s", quote.Rate, test.want)
+			}
+			if quote.Source != ExchangeRateHostSource {
+				t.Errorf("got source %q, want %q", quote.Source, ExchangeRateHostSource)
+			}
+		})
+	}
+}
@@ -0,0 +1,199 @@
+package exchange
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// historyCacheSize is how many periods between fetches HistoricalRates keeps,
+// enough for the events of a replay converted at the same time.
+const historyCacheSize = 64
+
+// RateHistory keeps every fetched rate table in Postgres.
+type RateHistory struct {
+	db *sql.DB
+}
+
+func NewRateHistory(db *sql.DB) *RateHistory {
+	return &RateHistory{
+		db: db,
+	}
+}
+
+// Record stores a table fetched at the given time.
+func (h *RateHistory) Record(ctx context.Context, table Table, fetchedAt time.Time) error {
+	rates, err := json.Marshal(table.Rates)
+	if err != nil {
+		return fmt.Errorf("encode rates: %v", err)
+	}
+
+	query := `INSERT INTO exchange_rate_snapshots (source, base, as_of, fetched_at, rates) VALUES ($1, $2, $3, $4, $5)`
+	_, err = h.db.ExecContext(ctx, query, table.Source, table.Base, table.AsOf, fetchedAt, string(rates))
+	if err != nil {
+		return fmt.Errorf("record exchange rates: %v", err)
+	}
+
+	return nil
+}
+
+// historyPeriod is a table along with the time it was in effect from, and
+// until if a later one was fetched.
+type historyPeriod struct {
+	table Table
+	from  time.Time
+	until sql.NullTime
+}
+
+func (p historyPeriod) covers(t time.Time) bool {
+	return !t.Before(p.from) && p.until.Valid && t.Before(p.until.Time)
+}
+
+// at returns the last table fetched at or before t. It returns false if the
+// history starts after t.
+func (h *RateHistory) at(ctx context.Context, t time.Time) (historyPeriod, bool, error) {
+	query := `SELECT source, base, as_of, fetched_at, rates,
+			(SELECT min(fetched_at) FROM exchange_rate_snapshots WHERE fetched_at > $1)
+		FROM exchange_rate_snapshots
+		WHERE fetched_at <= $1
+		ORDER BY fetched_at DESC, id DESC
+		LIMIT 1`
+	row := h.db.QueryRowContext(ctx, query, t)
+
+	var period historyPeriod
+	var rates []byte
+	err := row.Scan(&period.table.Source, &period.table.Base, &period.table.AsOf, &period.from, &rates, &period.until)
+	if err == sql.ErrNoRows {
+		return historyPeriod{}, false, nil
+	}
+	if err != nil {
+		return historyPeriod{}, false, fmt.Errorf("query exchange rate history: %v", err)
+	}
+
+	period.table.Rates = make(map[string]decimal.Decimal)
+	err = json.Unmarshal(rates, &period.table.Rates)
+	if err != nil {
+		return historyPeriod{}, false, fmt.Errorf("decode exchange rate history: %v", err)
+	}
+
+	return period, true, nil
+}
+
+// Recording records every table the provider fetches.
+func (h *RateHistory) Recording(provider TableProvider) TableProvider {
+	return &recordingProvider{
+		history:  h,
+		provider: provider,
+	}
+}
+
+type recordingProvider struct {
+	history  *RateHistory
+	provider TableProvider
+}
+
+func (p *recordingProvider) Name() string {
+	return p.provider.Name()
+}
+
+func (p *recordingProvider) Table(ctx context.Context) (Table, error) {
+	table, err := p.provider.Table(ctx)
+	if err != nil {
+		return Table{}, err
+	}
+
+	// Events can still be converted with rates missing from the history.
+	err = p.history.Record(ctx, table, time.Now().UTC())
+	if err != nil {
+		log.Printf("error recording exchange rates: %v", err)
+	}
+
+	return table, nil
+}
+
+// HistoricalRates converts with the rates in effect at a given time: the live
+// snapshot for times since it was fetched, otherwise the last table in the
+// history fetched before then. Times the history does not reach back to, or
+// cannot be read for, are converted at live rates, as are unknown (zero) times
+// and all times when there is no history.
+type HistoricalRates struct {
+	history *RateHistory
+	live    *SnapshotRates
+
+	mu      sync.Mutex
+	periods []historyPeriod
+}
+
+func NewHistoricalRates(history *RateHistory, live *SnapshotRates) *HistoricalRates {
+	return &HistoricalRates{
+		history: history,
+		live:    live,
+	}
+}
+
+func (r *HistoricalRates) Rate(ctx context.Context, from, to string) (Quote, error) {
+	return r.live.Rate(ctx, from, to)
+}
+
+// RateAt returns the rate from one currency to another in effect at t.
+func (r *HistoricalRates) RateAt(ctx context.Context, from, to string, t time.Time) (Quote, error) {
+	if r.history == nil || t.IsZero() {
+		return r.live.Rate(ctx, from, to)
+	}
+
+	snapshot, err := r.live.Snapshot(ctx)
+	if err == nil && !t.Before(snapshot.FetchedAt) {
+		return r.live.Rate(ctx, from, to)
+	}
+
+	period, ok := r.cached(t)
+	if !ok {
+		var found bool
+		period, found, err = r.history.at(ctx, t)
+		if err != nil {
+			log.Printf("error reading exchange rate history at %s, using live rates: %v", t.Format(time.RFC3339), err)
+			return r.live.Rate(ctx, from, to)
+		}
+		if !found {
+			log.Printf("No exchange rates in history at %s, using live rates", t.Format(time.RFC3339))
+			return r.live.Rate(ctx, from, to)
+		}
+		r.cache(period)
+	}
+
+	return period.table.Quote(from, to)
+}
+
+func (r *HistoricalRates) cached(t time.Time) (historyPeriod, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, period := range r.periods {
+		if period.covers(t) {
+			return period, true
+		}
+	}
+
+	return historyPeriod{}, false
+}
+
+// cache keeps periods that ended, as a later fetch cannot change them.
+func (r *HistoricalRates) cache(period historyPeriod) {
+	if !period.until.Valid {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.periods) >= historyCacheSize {
+		r.periods = r.periods[1:]
+	}
+	r.periods = append(r.periods, period)
+}
@@ -0,0 +1,214 @@
+package exchange
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	_ "github.com/lib/pq"
+	"github.com/shopspring/decimal"
+)
+
+var history = &historyDriver{}
+
+func init() {
+	sql.Register("history", history)
+}
+
+func TestHistoricalRatesAtEventTime(t *testing.T) {
+	day := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	// Recorded out of order, so that only the fetch times tell them apart.
+	history.snapshots = []historySnapshot{
+		{fetchedAt: day.Add(12 * time.Hour), rates: `{"EUR":"1","GBP":"0.9"}`},
+		{fetchedAt: day, rates: `{"EUR":"1","GBP":"0.8"}`},
+		{fetchedAt: day.Add(6 * time.Hour), rates: `{"EUR":"1","GBP":"0.85"}`},
+	}
+
+	db, err := sql.Open("history", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	live := fixedTable{table: Table{Base: "EUR", Source: "live", Rates: map[string]decimal.Decimal{"EUR": decimal.NewFromInt(1), "GBP": decimal.RequireFromString("0.5")}}}
+	historical := NewHistoricalRates(NewRateHistory(db), NewSnapshotRates(nil, live, time.Hour, time.Hour, 16))
+
+	tests := []struct {
+		name      string
+		at        time.Time
+		want      string
+		wantQuery bool
+	}{
+		{name: "after the first fetch", at: day.Add(time.Hour), want: "0.8", wantQuery: true},
+		{name: "after the second fetch", at: day.Add(7 * time.Hour), want: "0.85", wantQuery: true},
+		{name: "after the last fetch", at: day.Add(13 * time.Hour), want: "0.9", wantQuery: true},
+		{name: "within a cached period", at: day.Add(2 * time.Hour), want: "0.8"},
+		{name: "unknown time", want: "0.5"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			history.queried = nil
+			quote, err := historical.RateAt(context.Background(), "EUR", "GBP", test.at)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if quote.Rate.String() != test.want {
+				t.Errorf("got rate %s, want %s", quote.Rate, test.want)
+			}
+
+			var want []time.Time
+			if test.wantQuery {
+				want = []time.Time{test.at}
+			}
+			if len(history.queried) != len(want) || (len(want) > 0 && !history.queried[0].Equal(want[0])) {
+				t.Errorf("looked up history at %v, want %v", history.queried, want)
+			}
+		})
+	}
+}
+
+func TestHistoricalRatesFallBackToLiveRates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rates.json")
+	err := os.WriteFile(path, []byte(`{"base":"EUR","rates":{"GBP":"0.8"}}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing listens on port 1, so every query fails.
+	db, err := sql.Open("postgres", "postgres://casino@127.0.0.1:1/casino?sslmode=disable&connect_timeout=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	tests := []struct {
+		name    string
+		history *RateHistory
+	}{
+		{name: "no history"},
+		{name: "history unreachable", history: NewRateHistory(db)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			live := NewSnapshotRates(nil, NewStaticFile(path), time.Minute, time.Hour, 16)
+			rates := NewHistoricalRates(test.history, live)
+
+			quote, err := rates.RateAt(context.Background(), "GBP", "EUR", time.Now().Add(-time.Hour))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if quote.Rate.String() != "1.25" {
+				t.Errorf("got rate %s, want 1.25", quote.Rate)
+			}
+		})
+	}
+}
+
+type historySnapshot struct {
+	fetchedAt time.Time
+	rates     string
+}
+
+// historyDriver answers the lookups of RateHistory.at from snapshots and
+// records the times they were made for.
+type historyDriver struct {
+	mu        sync.Mutex
+	snapshots []historySnapshot
+	queried   []time.Time
+}
+
+func (d *historyDriver) Open(name string) (driver.Conn, error) {
+	return historyConn{driver: d}, nil
+}
+
+type historyConn struct {
+	driver *historyDriver
+}
+
+func (c historyConn) Prepare(query string) (driver.Stmt, error) {
+	if !strings.Contains(query, "FROM exchange_rate_snapshots") {
+		return nil, errors.New("unexpected query: " + query)
+	}
+	return historyStmt{driver: c.driver}, nil
+}
+
+func (c historyConn) Close() error {
+	return nil
+}
+
+func (c historyConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("transactions are not supported")
+}
+
+type historyStmt struct {
+	driver *historyDriver
+}
+
+func (s historyStmt) Close() error {
+	return nil
+}
+
+func (s historyStmt) NumInput() int {
+	return 1
+}
+
+func (s historyStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return nil, errors.New("exec is not supported")
+}
+
+func (s historyStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.driver.mu.Lock()
+	defer s.driver.mu.Unlock()
+
+	t := args[0].(time.Time)
+	s.driver.queried = append(s.driver.queried, t)
+
+	var last *historySnapshot
+	var next interface{}
+	for i, snapshot := range s.driver.snapshots {
+		if !snapshot.fetchedAt.After(t) {
+			if last == nil || snapshot.fetchedAt.After(last.fetchedAt) {
+				last = &s.driver.snapshots[i]
+			}
+		} else if next == nil || snapshot.fetchedAt.Before(next.(time.Time)) {
+			next = snapshot.fetchedAt
+		}
+	}
+
+	rows := &historyRows{}
+	if last != nil {
+		rows.values = [][]driver.Value{{"test", "EUR", last.fetchedAt, last.fetchedAt, []byte(last.rates), next}}
+	}
+	return rows, nil
+}
+
+type historyRows struct {
+	values [][]driver.Value
+}
+
+func (r *historyRows) Columns() []string {
+	return []string{"source", "base", "as_of", "fetched_at", "rates", "min"}
+}
+
+func (r *historyRows) Close() error {
+	return nil
+}
+
+func (r *historyRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
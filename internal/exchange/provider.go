@@ -0,0 +1,28 @@
+package exchange
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/Bitstarz-eng/event-processing-challenge/internal/casino"
+	"github.com/Bitstarz-eng/event-processing-challenge/internal/config"
+)
+
+// NewTableProvider creates the chain of providers configured for the stage.
+func NewTableProvider(exchangeCfg config.Exchange, httpClient *http.Client) (*Chain, error) {
+	providers := make([]TableProvider, len(exchangeCfg.Providers))
+	for i, name := range exchangeCfg.Providers {
+		switch name {
+		case ExchangeRateHostSource:
+			providers[i] = NewExchangeRateHost(httpClient, exchangeCfg.APIURL, exchangeCfg.APIKey)
+		case ECBSource:
+			providers[i] = NewECB(httpClient, exchangeCfg.ECBURL)
+		case StaticSource:
+			providers[i] = NewStaticFile(exchangeCfg.StaticFile)
+		default:
+			return nil, fmt.Errorf("unknown exchange rate provider %q", name)
+		}
+	}
+
+	return NewChain(exchangeCfg.Timeout, casino.Currencies, providers...), nil
+}
@@ -0,0 +1,31 @@
+package exchange
+
+import (
+	"context"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// RateProvider returns how many units of one currency a unit of another is
+// worth.
+type RateProvider interface {
+	Rate(ctx context.Context, from, to string) (Quote, error)
+}
+
+// HistoricalRateProvider returns rates as they were at a given time.
+type HistoricalRateProvider interface {
+	RateAt(ctx context.Context, from, to string, t time.Time) (Quote, error)
+}
+
+// Quote is a rate along with where it came from.
+type Quote struct {
+	Rate decimal.Decimal
+	// Source names the provider of the rate.
+	Source string
+	// AsOf is when the provider published the rate.
+	AsOf time.Time
+	// Stale is set when the rate outlived its time to live because no newer
+	// one could be fetched.
+	Stale bool
+}
@@ -0,0 +1,45 @@
+package exchange
+
+import (
+	"errors"
+	"sync"
+)
+
+var errRefreshPanicked = errors.New("refreshing exchange rates panicked")
+
+// flight collapses concurrent refreshes into one, handing its result to every
+// caller that asked while it was running.
+type flight struct {
+	mu   sync.Mutex
+	call *flightCall
+}
+
+type flightCall struct {
+	done     chan struct{}
+	snapshot *Snapshot
+	err      error
+}
+
+func (f *flight) do(fn func() (*Snapshot, error)) (*Snapshot, error) {
+	f.mu.Lock()
+	if call := f.call; call != nil {
+		f.mu.Unlock()
+		<-call.done
+		return call.snapshot, call.err
+	}
+	// The error stays if fn panics, so that callers waiting for it do not
+	// take the missing snapshot for a result.
+	call := &flightCall{done: make(chan struct{}), err: errRefreshPanicked}
+	f.call = call
+	f.mu.Unlock()
+
+	defer func() {
+		f.mu.Lock()
+		f.call = nil
+		f.mu.Unlock()
+		close(call.done)
+	}()
+
+	call.snapshot, call.err = fn()
+	return call.snapshot, call.err
+}
@@ -0,0 +1,43 @@
+package exchange
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFlightPanicReleasesWaiters(t *testing.T) {
+	var f flight
+	started := make(chan struct{})
+	release := make(chan struct{})
+
+	go func() {
+		defer func() { recover() }()
+		f.do(func() (*Snapshot, error) {
+			close(started)
+			<-release
+			panic("provider failed")
+		})
+	}()
+
+	<-started
+	waited := make(chan error, 1)
+	go func() {
+		_, err := f.do(func() (*Snapshot, error) {
+			return &Snapshot{}, nil
+		})
+		waited <- err
+	}()
+
+	// Give the waiter time to join the call before it panics.
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+
+	select {
+	case err := <-waited:
+		if err == nil {
+			t.Error("waiter got no error from a call that panicked")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("waiter still blocked after the call panicked")
+	}
+}
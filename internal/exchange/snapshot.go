@@ -0,0 +1,421 @@
+package exchange
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	snapshotKey = "exchange_rates:snapshot"
+	versionKey  = "exchange_rates:version"
+	lockKey     = "exchange_rates:lock"
+
+	// lockTTL bounds how long a replica that died while refreshing holds up
+	// the others. A replica still fetching extends its lock every
+	// lockExtendInterval, however long the providers take.
+	lockTTL            = 15 * time.Second
+	lockExtendInterval = lockTTL / 3
+	pollInterval       = 100 * time.Millisecond
+
+	// revalidateTimeout bounds a refresh running in the background, and
+	// revalidateInterval spaces them out while the provider is failing.
+	revalidateTimeout  = 2 * lockTTL
+	revalidateInterval = 5 * time.Second
+)
+
+// unlockScript releases the refresh lock only if it is still held with the
+// given token.
+var unlockScript = redis.NewScript(`
+if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("del", KEYS[1])
+end
+return 0
+`)
+
+// extendScript resets the expiry of the refresh lock only if it is still held
+// with the given token.
+var extendScript = redis.NewScript(`
+if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("pexpire", KEYS[1], ARGV[2])
+end
+return 0
+`)
+
+// TableProvider fetches the rates of all currencies at once.
+type TableProvider interface {
+	Name() string
+	Table(ctx context.Context) (Table, error)
+}
+
+// Snapshot is one fetch of the rate table. Versions grow with every fetch
+// among all replicas.
+type Snapshot struct {
+	Version   int64     `json:"version"`
+	FetchedAt time.Time `json:"fetched_at"`
+	Table     Table     `json:"table"`
+}
+
+// SnapshotRates serves rates from a snapshot of the whole rate table, so that
+// all rates used at a time come from the same fetch. When the snapshot is
+// older than ttl, one goroutine refreshes it while the others wait, and
+// replicas take turns through a Redis lock, sharing the snapshot in Redis.
+// Without a Redis client every replica fetches its own.
+//
+// Until it is maxStaleness old, counted like ttl from its fetch, an expired
+// snapshot keeps being served while it is refreshed in the background, and
+// when refreshing fails. The snapshot shared in Redis expires at that age too.
+//
+// Rates between pairs of currencies are kept in an LRU cache until their
+// snapshot expires, so that most lookups do not touch the table.
+type SnapshotRates struct {
+	counters cacheCounters
+
+	redisClient  *redis.Client
+	provider     TableProvider
+	ttl          time.Duration
+	maxStaleness time.Duration
+	snapshot     atomic.Value
+	quotes       *quoteCache
+	flight       flight
+	revalidating int32
+	// Unix nanoseconds of the last background refresh.
+	revalidatedAt int64
+}
+
+// NewSnapshotRates caches up to cacheSize pairs of currencies. redisClient may
+// be nil for replicas not to share rates. A maxStaleness shorter than ttl is
+// raised to ttl.
+func NewSnapshotRates(redisClient *redis.Client, provider TableProvider, ttl, maxStaleness time.Duration, cacheSize int) *SnapshotRates {
+	if maxStaleness < ttl {
+		maxStaleness = ttl
+	}
+	return &SnapshotRates{
+		redisClient:  redisClient,
+		provider:     provider,
+		ttl:          ttl,
+		maxStaleness: maxStaleness,
+		quotes:       newQuoteCache(cacheSize),
+	}
+}
+
+func (r *SnapshotRates) Rate(ctx context.Context, from, to string) (Quote, error) {
+	key := from + "/" + to
+	quote, result := r.quotes.get(key, time.Now())
+	switch result {
+	case cacheHit:
+		atomic.AddInt64(&r.counters.hits, 1)
+		return quote, nil
+	case cacheExpired:
+		atomic.AddInt64(&r.counters.expired, 1)
+	default:
+		atomic.AddInt64(&r.counters.misses, 1)
+	}
+
+	snapshot, err := r.Snapshot(ctx)
+	if err != nil {
+		return Quote{}, err
+	}
+
+	quote, err = snapshot.Table.Quote(from, to)
+	if err != nil {
+		return Quote{}, err
+	}
+	quote.Stale = !r.fresh(snapshot)
+	// Stale rates are looked up again, so that they are replaced as soon
+	// as the snapshot is.
+	if !quote.Stale {
+		r.quotes.put(key, quote, snapshot.FetchedAt.Add(r.ttl))
+	}
+
+	return quote, nil
+}
+
+// Snapshot returns the current snapshot. An expired one is returned as long as
+// it is usable and refreshed in the background, otherwise it is refreshed
+// first.
+func (r *SnapshotRates) Snapshot(ctx context.Context) (*Snapshot, error) {
+	snapshot := r.current()
+	if r.fresh(snapshot) {
+		return snapshot, nil
+	}
+	if r.usable(snapshot) {
+		r.revalidate()
+		return snapshot, nil
+	}
+
+	return r.flight.do(func() (*Snapshot, error) {
+		return r.refresh(ctx)
+	})
+}
+
+// revalidate refreshes the snapshot in the background unless it already is.
+func (r *SnapshotRates) revalidate() {
+	if time.Since(time.Unix(0, atomic.LoadInt64(&r.revalidatedAt))) < revalidateInterval {
+		return
+	}
+	if !atomic.CompareAndSwapInt32(&r.revalidating, 0, 1) {
+		return
+	}
+	atomic.StoreInt64(&r.revalidatedAt, time.Now().UnixNano())
+
+	go func() {
+		defer atomic.StoreInt32(&r.revalidating, 0)
+
+		ctx, cancel := context.WithTimeout(context.Background(), revalidateTimeout)
+		defer cancel()
+
+		_, err := r.flight.do(func() (*Snapshot, error) {
+			return r.refresh(ctx)
+		})
+		if err != nil {
+			log.Printf("error refreshing exchange rates in the background: %v", err)
+		}
+	}()
+}
+
+func (r *SnapshotRates) current() *Snapshot {
+	snapshot, _ := r.snapshot.Load().(*Snapshot)
+	return snapshot
+}
+
+func (r *SnapshotRates) fresh(snapshot *Snapshot) bool {
+	return snapshot != nil && time.Since(snapshot.FetchedAt) < r.ttl
+}
+
+// usable tells whether a snapshot, fresh or expired, is younger than
+// maxStaleness.
+func (r *SnapshotRates) usable(snapshot *Snapshot) bool {
+	return snapshot != nil && time.Since(snapshot.FetchedAt) < r.maxStaleness
+}
+
+// newest returns the later of two snapshots, either of which may be nil.
+func newest(a, b *Snapshot) *Snapshot {
+	if a == nil || (b != nil && b.Version > a.Version) {
+		return b
+	}
+	return a
+}
+
+func (r *SnapshotRates) refresh(ctx context.Context) (*Snapshot, error) {
+	// A refresh may have finished just before this one started.
+	snapshot := r.current()
+	if r.fresh(snapshot) {
+		return snapshot, nil
+	}
+
+	var shared *Snapshot
+	if r.redisClient != nil {
+		var err error
+		shared, err = r.load(ctx)
+		if err != nil {
+			log.Printf("error loading shared exchange rates: %v", err)
+		} else if r.fresh(shared) {
+			atomic.AddInt64(&r.counters.sharedHits, 1)
+			r.snapshot.Store(shared)
+			return shared, nil
+		}
+		atomic.AddInt64(&r.counters.sharedMisses, 1)
+
+		token, err := lockToken()
+		if err != nil {
+			return nil, err
+		}
+		locked, err := r.redisClient.SetNX(ctx, lockKey, token, lockTTL).Result()
+		if err != nil {
+			// Without Redis every replica fetches on its own.
+			log.Printf("error locking exchange rate refresh: %v", err)
+		} else if locked {
+			release := r.holdLock(token)
+			defer release()
+		} else {
+			shared := r.await(ctx)
+			if shared != nil {
+				atomic.AddInt64(&r.counters.sharedHits, 1)
+				r.snapshot.Store(shared)
+				return shared, nil
+			}
+			log.Printf("Exchange rate refresh by another replica timed out, fetching rates")
+		}
+	}
+
+	table, err := r.provider.Table(ctx)
+	if err != nil {
+		atomic.AddInt64(&r.counters.fetchErrors, 1)
+		last := newest(snapshot, shared)
+		if r.usable(last) {
+			log.Printf("error refreshing exchange rates, serving snapshot version %d fetched from %s at %s: %v", last.Version, last.Table.Source, last.FetchedAt.Format(time.RFC3339), err)
+			r.snapshot.Store(last)
+			return last, nil
+		}
+		return nil, fmt.Errorf("refresh exchange rates: %v", err)
+	}
+	atomic.AddInt64(&r.counters.fetches, 1)
+
+	snapshot = &Snapshot{
+		FetchedAt: time.Now().UTC(),
+		Table:     table,
+	}
+	snapshot.Version = r.nextVersion(ctx)
+	if r.redisClient != nil {
+		r.save(ctx, snapshot)
+	}
+
+	log.Printf("Refreshed exchange rates from %s, snapshot version %d", table.Source, snapshot.Version)
+	r.snapshot.Store(snapshot)
+	return snapshot, nil
+}
+
+// nextVersion numbers a new snapshot, among all replicas when they share
+// snapshots in Redis.
+func (r *SnapshotRates) nextVersion(ctx context.Context) int64 {
+	if r.redisClient != nil {
+		version, err := r.redisClient.Incr(ctx, versionKey).Result()
+		if err == nil {
+			return version
+		}
+		log.Printf("error versioning exchange rates: %v", err)
+	}
+
+	current := r.current()
+	if current == nil {
+		return 1
+	}
+	return current.Version + 1
+}
+
+// load returns the snapshot shared in Redis, or nil if there is none.
+func (r *SnapshotRates) load(ctx context.Context) (*Snapshot, error) {
+	data, err := r.redisClient.Get(ctx, snapshotKey).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshot Snapshot
+	err = json.Unmarshal(data, &snapshot)
+	if err != nil {
+		return nil, fmt.Errorf("decode exchange rate snapshot: %v", err)
+	}
+
+	return &snapshot, nil
+}
+
+func (r *SnapshotRates) save(ctx context.Context, snapshot *Snapshot) {
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		log.Printf("error encoding exchange rate snapshot: %v", err)
+		return
+	}
+
+	// Snapshots are saved as they are fetched, so the key lives as long as the
+	// snapshot is usable.
+	err = r.redisClient.Set(ctx, snapshotKey, data, r.maxStaleness).Err()
+	if err != nil {
+		log.Printf("error sharing exchange rate snapshot: %v", err)
+	}
+}
+
+// await waits for the replica holding the lock to share a fresh snapshot. It
+// returns nil if none turns up before the lock is released or expires.
+func (r *SnapshotRates) await(ctx context.Context) *Snapshot {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		held, err := r.redisClient.Exists(ctx, lockKey).Result()
+		if err != nil {
+			log.Printf("error checking exchange rate refresh lock: %v", err)
+			continue
+		}
+
+		// The snapshot is shared before the lock is released, so it is
+		// looked up once more after the lock is gone.
+		shared, err := r.load(ctx)
+		if err != nil {
+			log.Printf("error loading shared exchange rates: %v", err)
+			continue
+		}
+		if r.fresh(shared) {
+			return shared
+		}
+		if held == 0 {
+			return nil
+		}
+	}
+}
+
+// holdLock extends the refresh lock until the returned function is called,
+// which then releases it.
+func (r *SnapshotRates) holdLock(token string) func() {
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+
+		ticker := time.NewTicker(lockExtendInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			held, err := extendScript.Run(ctx, r.redisClient, []string{lockKey}, token, lockTTL.Milliseconds()).Int()
+			cancel()
+			if err != nil {
+				log.Printf("error extending exchange rate refresh lock: %v", err)
+				continue
+			}
+			if held == 0 {
+				log.Printf("Exchange rate refresh lock expired before it could be extended")
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(stop)
+		<-stopped
+		r.unlock(token)
+	}
+}
+
+func (r *SnapshotRates) unlock(token string) {
+	// The lock has to go even if the refresh was cancelled.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	err := unlockScript.Run(ctx, r.redisClient, []string{lockKey}, token).Err()
+	if err != nil {
+		log.Printf("error unlocking exchange rate refresh: %v", err)
+	}
+}
+
+func lockToken() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", fmt.Errorf("generate lock token: %v", err)
+	}
+
+	return hex.EncodeToString(b), nil
+}
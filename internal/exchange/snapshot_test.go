@@ -0,0 +1,47 @@
+package exchange
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestSnapshotRatesStaleness(t *testing.T) {
+	table := Table{Base: "EUR", Source: "ecb", Rates: map[string]decimal.Decimal{"EUR": decimal.NewFromInt(1), "USD": decimal.RequireFromString("1.1")}}
+	down := fixedTable{table: Table{Source: "api"}, err: errors.New("unavailable")}
+
+	tests := []struct {
+		name      string
+		age       time.Duration
+		wantStale bool
+		wantErr   bool
+	}{
+		{name: "fresh", age: 30 * time.Second},
+		{name: "expired", age: 2 * time.Minute, wantStale: true},
+		{name: "too old", age: 10 * time.Minute, wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rates := NewSnapshotRates(nil, down, time.Minute, 5*time.Minute, 16)
+			rates.snapshot.Store(&Snapshot{Version: 1, FetchedAt: time.Now().Add(-test.age), Table: table})
+
+			quote, err := rates.Rate(context.Background(), "EUR", "USD")
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("got rate %s from a snapshot %v old, want an error", quote.Rate, test.age)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if quote.Stale != test.wantStale {
+				t.Errorf("got stale %v, want %v", quote.Stale, test.wantStale)
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package exchange
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/shopspring/decimal"
+)
+
+// StaticSource names the static rates file on quotes.
+const StaticSource = "static"
+
+// StaticFile reads fixed rates from a JSON file in the form of a Table, for
+// working offline or as the last resort of a Chain.
+type StaticFile struct {
+	path string
+}
+
+func NewStaticFile(path string) *StaticFile {
+	return &StaticFile{
+		path: path,
+	}
+}
+
+func (p *StaticFile) Name() string {
+	return StaticSource
+}
+
+func (p *StaticFile) Table(ctx context.Context) (Table, error) {
+	data, err := os.ReadFile(p.path)
+	if err != nil {
+		return Table{}, fmt.Errorf("read static rates: %v", err)
+	}
+
+	var table Table
+	err = json.Unmarshal(data, &table)
+	if err != nil {
+		return Table{}, fmt.Errorf("decode static rates %s: %v", p.path, err)
+	}
+	if table.Base == "" {
+		return Table{}, fmt.Errorf("invalid static rates %s, missing base currency", p.path)
+	}
+	if len(table.Rates) == 0 {
+		return Table{}, fmt.Errorf("invalid static rates %s, missing rates", p.path)
+	}
+	for currency, rate := range table.Rates {
+		if !rate.IsPositive() {
+			return Table{}, fmt.Errorf("invalid static rate %s for %s", rate, currency)
+		}
+	}
+
+	table.Source = StaticSource
+	table.Rates[table.Base] = decimal.NewFromInt(1)
+	return table, nil
+}
@@ -0,0 +1,96 @@
+package exchange
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// ratePrecision is the number of decimal places derived rates are rounded to,
+// enough for rates of currencies worth a tiny fraction of another.
+const ratePrecision = 24
+
+// Table holds how many units of every currency one unit of the base currency
+// is worth. Rates between any two of its currencies are derived through the
+// base.
+type Table struct {
+	Base string `json:"base"`
+	// Source names the provider the table was fetched from.
+	Source string                     `json:"source"`
+	AsOf   time.Time                  `json:"as_of"`
+	Rates  map[string]decimal.Decimal `json:"rates"`
+}
+
+// NewTable normalises a quote table as returned by APIs like exchangerate.host,
+// keyed by the source currency followed by the quoted one, like "USDEUR".
+func NewTable(source string, quotes map[string]decimal.Decimal) (Table, error) {
+	table := Table{
+		Base:  source,
+		Rates: map[string]decimal.Decimal{source: decimal.NewFromInt(1)},
+	}
+
+	for key, rate := range quotes {
+		currency := strings.TrimPrefix(key, source)
+		if currency == key || currency == "" {
+			return Table{}, fmt.Errorf("quote %s is not relative to %s", key, source)
+		}
+		if !rate.IsPositive() {
+			return Table{}, fmt.Errorf("invalid rate %s for quote %s", rate, key)
+		}
+		table.Rates[currency] = rate
+	}
+
+	return table, nil
+}
+
+// Rate returns how many units of to one unit of from is worth.
+func (t Table) Rate(from, to string) (decimal.Decimal, error) {
+	fromRate, ok := t.Rates[from]
+	if !ok {
+		return decimal.Decimal{}, fmt.Errorf("no rate for %s", from)
+	}
+	toRate, ok := t.Rates[to]
+	if !ok {
+		return decimal.Decimal{}, fmt.Errorf("no rate for %s", to)
+	}
+
+	return toRate.DivRound(fromRate, ratePrecision), nil
+}
+
+// Quote returns the rate from one currency to another, noting where it came
+// from.
+func (t Table) Quote(from, to string) (Quote, error) {
+	rate, err := t.Rate(from, to)
+	if err != nil {
+		return Quote{}, err
+	}
+
+	return Quote{
+		Rate:   rate,
+		Source: t.Source,
+		AsOf:   t.AsOf,
+	}, nil
+}
+
+// Rebase expresses the table relative to another of its currencies.
+func (t Table) Rebase(base string) (Table, error) {
+	baseRate, ok := t.Rates[base]
+	if !ok {
+		return Table{}, fmt.Errorf("no rate for %s", base)
+	}
+
+	rebased := Table{
+		Base:   base,
+		Source: t.Source,
+		AsOf:   t.AsOf,
+		Rates:  make(map[string]decimal.Decimal, len(t.Rates)),
+	}
+	for currency, rate := range t.Rates {
+		rebased.Rates[currency] = rate.DivRound(baseRate, ratePrecision)
+	}
+	rebased.Rates[base] = decimal.NewFromInt(1)
+
+	return rebased, nil
+}
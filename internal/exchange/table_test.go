@@ -0,0 +1,50 @@
+package exchange
+
+import (
+	"testing"
+
+	"github.com/shopspring/decimal"
+)
+
+func TestTableRebase(t *testing.T) {
+	table := Table{
+		Base:   "USD",
+		Source: "api",
+		Rates: map[string]decimal.Decimal{
+			"USD": decimal.NewFromInt(1),
+			"EUR": decimal.RequireFromString("0.8"),
+			"GBP": decimal.RequireFromString("0.64"),
+		},
+	}
+
+	rebased, err := table.Rebase("EUR")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rebased.Base != "EUR" || rebased.Source != "api" {
+		t.Errorf("got base %s and source %s, want EUR and api", rebased.Base, rebased.Source)
+	}
+
+	want := map[string]string{"EUR": "1", "USD": "1.25", "GBP": "0.8"}
+	for currency, rate := range want {
+		if got := rebased.Rates[currency]; !got.Equal(decimal.RequireFromString(rate)) {
+			t.Errorf("got %s rate %s, want %s", currency, got, rate)
+		}
+	}
+
+	// Rates between currencies do not depend on the base.
+	for _, from := range []string{"USD", "EUR", "GBP"} {
+		for _, to := range []string{"USD", "EUR", "GBP"} {
+			before, _ := table.Rate(from, to)
+			after, _ := rebased.Rate(from, to)
+			if !before.Equal(after) {
+				t.Errorf("%s to %s changed from %s to %s", from, to, before, after)
+			}
+		}
+	}
+
+	_, err = table.Rebase("BTC")
+	if err == nil {
+		t.Error("got no error rebasing on a currency without a rate")
+	}
+}
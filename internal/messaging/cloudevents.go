@@ -0,0 +1,149 @@
+package messaging
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Bitstarz-eng/event-processing-challenge/internal/casino"
+)
+
+// CloudEvents 1.0 as bound to Kafka messages. In binary mode the attributes
+// travel in ce_ headers and the value is the encoded event, in structured mode
+// the value is a JSON envelope holding both.
+const (
+	CloudEventsSpecVersion     = "1.0"
+	CloudEventsTypePrefix      = "casino."
+	ContentTypeCloudEventsJSON = "application/cloudevents+json"
+
+	HeaderCloudEventsPrefix      = "ce_"
+	HeaderCloudEventsSpecVersion = "ce_specversion"
+	HeaderCloudEventsID          = "ce_id"
+	HeaderCloudEventsSource      = "ce_source"
+	HeaderCloudEventsType        = "ce_type"
+	HeaderCloudEventsTime        = "ce_time"
+)
+
+type CloudEventsMode string
+
+const (
+	CloudEventsOff        CloudEventsMode = ""
+	CloudEventsStructured CloudEventsMode = "structured"
+	CloudEventsBinary     CloudEventsMode = "binary"
+)
+
+func ParseCloudEventsMode(name string) (CloudEventsMode, error) {
+	switch name {
+	case "", "none":
+		return CloudEventsOff, nil
+	case "structured":
+		return CloudEventsStructured, nil
+	case "binary":
+		return CloudEventsBinary, nil
+	default:
+		return "", fmt.Errorf("unknown CloudEvents mode %q", name)
+	}
+}
+
+// structuredCloudEvent is the JSON envelope of structured mode. Data holds
+// JSON encoded events as they are, other encodings go into DataBase64.
+type structuredCloudEvent struct {
+	SpecVersion     string          `json:"specversion"`
+	ID              string          `json:"id"`
+	Source          string          `json:"source"`
+	Type            string          `json:"type"`
+	Time            string          `json:"time,omitempty"`
+	DataContentType string          `json:"datacontenttype,omitempty"`
+	Data            json.RawMessage `json:"data,omitempty"`
+	DataBase64      []byte          `json:"data_base64,omitempty"`
+}
+
+// wrapCloudEvent turns the encoded event into a CloudEvent produced by stage,
+// returning the message value and setting the headers of the mode.
+func wrapCloudEvent(mode CloudEventsMode, stage string, event casino.Event, codec Codec, data []byte, headers *Headers) ([]byte, error) {
+	id := strconv.Itoa(event.ID)
+	eventType := CloudEventsTypePrefix + event.Type
+	var eventTime string
+	if !event.CreatedAt.IsZero() {
+		eventTime = event.CreatedAt.Format(time.RFC3339Nano)
+	}
+
+	switch mode {
+	case CloudEventsBinary:
+		headers.Set(HeaderCloudEventsSpecVersion, CloudEventsSpecVersion)
+		headers.Set(HeaderCloudEventsID, id)
+		headers.Set(HeaderCloudEventsSource, stage)
+		headers.Set(HeaderCloudEventsType, eventType)
+		if eventTime != "" {
+			headers.Set(HeaderCloudEventsTime, eventTime)
+		}
+		headers.Set(HeaderContentType, codec.ContentType())
+		return data, nil
+	case CloudEventsStructured:
+		envelope := structuredCloudEvent{
+			SpecVersion:     CloudEventsSpecVersion,
+			ID:              id,
+			Source:          stage,
+			Type:            eventType,
+			Time:            eventTime,
+			DataContentType: codec.ContentType(),
+		}
+		if codec.ContentType() == ContentTypeJSON {
+			envelope.Data = data
+		} else {
+			envelope.DataBase64 = data
+		}
+
+		value, err := json.Marshal(envelope)
+		if err != nil {
+			return nil, fmt.Errorf("encode CloudEvent: %v", err)
+		}
+		headers.Set(HeaderContentType, ContentTypeCloudEventsJSON)
+		return value, nil
+	default:
+		return nil, fmt.Errorf("unknown CloudEvents mode %q", mode)
+	}
+}
+
+// isStructuredCloudEvent reports whether a content type is that of a
+// structured mode CloudEvent.
+func isStructuredCloudEvent(contentType string) bool {
+	mediaType := strings.TrimSpace(strings.SplitN(contentType, ";", 2)[0])
+	return strings.EqualFold(mediaType, ContentTypeCloudEventsJSON)
+}
+
+func decodeStructuredCloudEvent(value []byte) (casino.Event, error) {
+	var envelope structuredCloudEvent
+	err := json.Unmarshal(value, &envelope)
+	if err != nil {
+		return casino.Event{}, fmt.Errorf("decode CloudEvent: %v", err)
+	}
+	if envelope.SpecVersion != CloudEventsSpecVersion {
+		return casino.Event{}, fmt.Errorf("unsupported CloudEvents version %q", envelope.SpecVersion)
+	}
+
+	codec, err := CodecFor(envelope.DataContentType)
+	if err != nil {
+		return casino.Event{}, err
+	}
+
+	data := envelope.DataBase64
+	if data == nil {
+		data = envelope.Data
+	}
+	return codec.Decode(data)
+}
+
+// withoutCloudEvents drops the ce_ headers of an earlier stage.
+func withoutCloudEvents(headers Headers) Headers {
+	result := make(Headers, 0, len(headers))
+	for _, header := range headers {
+		if !strings.HasPrefix(header.Key, HeaderCloudEventsPrefix) {
+			result = append(result, header)
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,88 @@
+package messaging
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/Bitstarz-eng/event-processing-challenge/internal/casino"
+)
+
+// HeaderContentType names the codec a message value was encoded with.
+// Messages without it are JSON.
+const HeaderContentType = "content-type"
+
+const (
+	ContentTypeJSON     = "application/json"
+	ContentTypeProtobuf = "application/x-protobuf"
+)
+
+// Codec encodes events into message values and back.
+type Codec interface {
+	ContentType() string
+	Encode(event casino.Event) ([]byte, error)
+	Decode(data []byte) (casino.Event, error)
+}
+
+var (
+	JSONCodec     Codec = jsonCodec{}
+	ProtobufCodec Codec = protobufCodec{}
+)
+
+// ParseCodec returns the codec named "json" or "protobuf".
+func ParseCodec(name string) (Codec, error) {
+	switch name {
+	case "json":
+		return JSONCodec, nil
+	case "protobuf":
+		return ProtobufCodec, nil
+	default:
+		return nil, fmt.Errorf("unknown codec %q", name)
+	}
+}
+
+// CodecFor returns the codec of a content type, ignoring any parameters.
+func CodecFor(contentType string) (Codec, error) {
+	mediaType := strings.TrimSpace(strings.SplitN(contentType, ";", 2)[0])
+	switch strings.ToLower(mediaType) {
+	case "", ContentTypeJSON:
+		return JSONCodec, nil
+	case ContentTypeProtobuf:
+		return ProtobufCodec, nil
+	default:
+		return nil, fmt.Errorf("unsupported content type %q", contentType)
+	}
+}
+
+// DecodeEvent decodes the event in a message with the codec named by its
+// content type, unwrapping structured CloudEvents. Binary CloudEvents need no
+// unwrapping, as their content type is that of the event.
+func DecodeEvent(msg Message) (casino.Event, error) {
+	contentType, _ := msg.Headers.Get(HeaderContentType)
+	if isStructuredCloudEvent(contentType) {
+		return decodeStructuredCloudEvent(msg.Value)
+	}
+
+	codec, err := CodecFor(contentType)
+	if err != nil {
+		return casino.Event{}, err
+	}
+
+	return codec.Decode(msg.Value)
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() string {
+	return ContentTypeJSON
+}
+
+func (jsonCodec) Encode(event casino.Event) ([]byte, error) {
+	return json.Marshal(event)
+}
+
+func (jsonCodec) Decode(data []byte) (casino.Event, error) {
+	var event casino.Event
+	err := json.Unmarshal(data, &event)
+	return event, err
+}
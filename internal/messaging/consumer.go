@@ -2,61 +2,235 @@ package messaging
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"log"
+	"sync"
+	"time"
+)
 
-	"github.com/Bitstarz-eng/event-processing-challenge/internal/casino"
+const commitBufferSize = 256
 
-	kafka "github.com/segmentio/kafka-go"
+// Backoff between attempts to hand a failed message to the retry or
+// dead-letter topic.
+const (
+	minFailBackoff = 100 * time.Millisecond
+	maxFailBackoff = 5 * time.Second
 )
 
+// Consumer fetches events from a source until it is stopped. Its context
+// bounds everything else it does, so that events fetched before Stop can still
+// be handled and committed while the pipeline drains.
 type Consumer struct {
-	ctx     context.Context
-	reader  *kafka.Reader
-	eventCh chan<- casino.Event
+	ctx        context.Context
+	fetchCtx   context.Context
+	stop       context.CancelFunc
+	source     Source
+	eventCh    chan<- Envelope
+	offsets    *offsetTracker
+	progress   *consumerProgress
+	commitCh   chan Message
+	committed  chan struct{}
+	deadLetter *DeadLetter
+	retrier    *Retrier
+
+	mu     sync.Mutex
+	closed bool
+}
+
+type ConsumerOption func(*Consumer)
+
+// WithDeadLetter routes messages that cannot be decoded or processed to a
+// dead-letter topic instead of dropping them.
+func WithDeadLetter(deadLetter *DeadLetter) ConsumerOption {
+	return func(c *Consumer) {
+		c.deadLetter = deadLetter
+	}
+}
+
+// WithRetry sends messages that failed processing to delayed retry topics
+// before giving up on them.
+func WithRetry(retrier *Retrier) ConsumerOption {
+	return func(c *Consumer) {
+		c.retrier = retrier
+	}
 }
 
-func NewConsumer(ctx context.Context, reader *kafka.Reader, eventCh chan<- casino.Event) *Consumer {
-	return &Consumer{
-		ctx:     ctx,
-		reader:  reader,
-		eventCh: eventCh,
+func NewConsumer(ctx context.Context, source Source, eventCh chan<- Envelope, options ...ConsumerOption) *Consumer {
+	c := &Consumer{
+		ctx:       ctx,
+		source:    source,
+		eventCh:   eventCh,
+		offsets:   newOffsetTracker(),
+		progress:  newConsumerProgress(),
+		commitCh:  make(chan Message, commitBufferSize),
+		committed: make(chan struct{}),
+	}
+	c.fetchCtx, c.stop = context.WithCancel(ctx)
+	for _, option := range options {
+		option(c)
 	}
+
+	go c.commit()
+	return c
 }
 
+// Consume fetches events until the consumer is stopped.
 func (c *Consumer) Consume() {
 	for {
 		select {
-		case <-c.ctx.Done():
-			log.Println("Consumer context cancelled")
+		case <-c.fetchCtx.Done():
+			log.Println("Consumer stopped fetching")
 			return
 		default:
 			err := c.consumeMessage()
-			if err != nil {
+			if err != nil && c.fetchCtx.Err() == nil {
 				log.Printf("error consuming message: %v", err)
 			}
 		}
 	}
 }
 
-func (c *Consumer) consumeMessage() error {
-	m, err := c.reader.ReadMessage(c.ctx)
+// Stop makes Consume return without fetching further events.
+func (c *Consumer) Stop() {
+	c.stop()
+}
+
+// Close stops the consumer, commits the offsets acknowledged so far and closes
+// the source. Events acknowledged after Close are delivered again.
+func (c *Consumer) Close() error {
+	c.stop()
+
+	c.mu.Lock()
+	if !c.closed {
+		c.closed = true
+		close(c.commitCh)
+	}
+	c.mu.Unlock()
+
+	<-c.committed
+	return c.source.Close()
+}
+
+// Stats reports the lag and throughput of the consumer per partition, against
+// the end offsets of the partitions if the source can look them up.
+func (c *Consumer) Stats() ConsumerStats {
+	if lister, ok := c.source.(endOffsetLister); ok {
+		c.refreshEndOffsets(lister)
+	}
+	return c.progress.stats(time.Now())
+}
+
+func (c *Consumer) refreshEndOffsets(lister endOffsetLister) {
+	partitions := c.progress.partitionsByTopic()
+	if len(partitions) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.ctx, endOffsetsTimeout)
+	defer cancel()
+
+	offsets, err := lister.endOffsets(ctx, partitions)
 	if err != nil {
-		err := c.ctx.Err()
-		if err != nil {
-			return fmt.Errorf("context cancelled: %v", err)
-		}
+		log.Printf("error looking up end offsets, reporting lag from fetched messages: %v", err)
+		return
+	}
+	c.progress.endOffsets(offsets)
+}
 
-		return fmt.Errorf("read kafka message: %v", err)
+func (c *Consumer) consumeMessage() error {
+	m, err := c.source.FetchMessage(c.fetchCtx)
+	if err != nil {
+		return fmt.Errorf("fetch message: %v", err)
 	}
+	c.offsets.fetched(m)
+	c.progress.fetched(m, time.Now())
 
-	var event casino.Event
-	err = json.Unmarshal(m.Value, &event)
+	event, err := DecodeEvent(m)
 	if err != nil {
-		return fmt.Errorf("unmarshal kafka message: %v", err)
+		err = Permanent(fmt.Errorf("decode message: %v", err))
+		c.fail(m, err)
+		return err
 	}
 
-	c.eventCh <- event
+	c.eventCh <- Envelope{
+		Event:   event,
+		Headers: m.Headers,
+		msg:     m,
+		acker:   c,
+	}
 	return nil
 }
+
+func (c *Consumer) ack(msg Message) {
+	commitMsg, ok := c.offsets.ack(msg)
+	if !ok {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return
+	}
+
+	select {
+	case c.commitCh <- commitMsg:
+	case <-c.ctx.Done():
+	}
+}
+
+// fail schedules the message for a retry or dead-letters it, depending on
+// the error and the attempts so far, and acknowledges it so that the partition
+// can move past it. Sending is tried again with backoff until it succeeds or
+// the consumer's context ends, in which case the message is left
+// unacknowledged to be delivered again after a restart.
+func (c *Consumer) fail(msg Message, err error) {
+	attempts := attemptsOf(msg) + 1
+
+	backoff := minFailBackoff
+	for {
+		sendErr := c.handleFailure(msg, err, attempts)
+		if sendErr == nil {
+			break
+		}
+		log.Printf("error handling failed offset %d of %s/%d, trying again in %v: %v", msg.Offset, msg.Topic, msg.Partition, backoff, sendErr)
+
+		select {
+		case <-time.After(backoff):
+		case <-c.ctx.Done():
+			return
+		}
+		backoff *= 2
+		if backoff > maxFailBackoff {
+			backoff = maxFailBackoff
+		}
+	}
+	c.ack(msg)
+}
+
+func (c *Consumer) handleFailure(msg Message, err error, attempts int) error {
+	switch {
+	case c.retrier != nil && c.retrier.ShouldRetry(err, attempts):
+		return c.retrier.Send(c.ctx, msg, err, attempts)
+	case c.deadLetter != nil:
+		return c.deadLetter.Send(c.ctx, msg, err, attempts)
+	default:
+		log.Printf("dropping message at offset %d of %s/%d: %v", msg.Offset, msg.Topic, msg.Partition, err)
+		return nil
+	}
+}
+
+// commit commits acknowledged offsets, one partition position at a time, until
+// the consumer is closed.
+func (c *Consumer) commit() {
+	defer close(c.committed)
+
+	for msg := range c.commitCh {
+		err := c.source.CommitMessages(c.ctx, msg)
+		if err != nil {
+			log.Printf("error committing offset %d of %s/%d: %v", msg.Offset, msg.Topic, msg.Partition, err)
+			continue
+		}
+		c.progress.committed(msg)
+	}
+}
@@ -0,0 +1,117 @@
+package messaging
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+)
+
+const (
+	HeaderDeadLetterStage     = "dlq.stage"
+	HeaderDeadLetterError     = "dlq.error"
+	HeaderDeadLetterAttempts  = "dlq.attempts"
+	HeaderDeadLetterTopic     = "dlq.original.topic"
+	HeaderDeadLetterPartition = "dlq.original.partition"
+	HeaderDeadLetterOffset    = "dlq.original.offset"
+)
+
+var deadLetterHeaders = []string{
+	HeaderDeadLetterStage,
+	HeaderDeadLetterError,
+	HeaderDeadLetterAttempts,
+	HeaderDeadLetterTopic,
+	HeaderDeadLetterPartition,
+	HeaderDeadLetterOffset,
+}
+
+// DeadLetter parks messages a stage could not handle on a dead-letter topic,
+// keeping the original bytes and describing the failure in headers.
+type DeadLetter struct {
+	sink  Sink
+	stage string
+}
+
+func NewDeadLetter(sink Sink, stage string) *DeadLetter {
+	return &DeadLetter{
+		sink:  sink,
+		stage: stage,
+	}
+}
+
+func (d *DeadLetter) Send(ctx context.Context, msg Message, cause error, attempts int) error {
+	origin := originOf(msg)
+	headers := msg.Headers.Without(deadLetterHeaders...)
+	headers = headers.Without(retryHeaders...)
+	headers = append(headers,
+		Header{Key: HeaderDeadLetterStage, Value: []byte(d.stage)},
+		Header{Key: HeaderDeadLetterError, Value: []byte(cause.Error())},
+		Header{Key: HeaderDeadLetterAttempts, Value: []byte(strconv.Itoa(attempts))},
+		Header{Key: HeaderDeadLetterTopic, Value: []byte(origin.Topic)},
+		Header{Key: HeaderDeadLetterPartition, Value: []byte(strconv.Itoa(origin.Partition))},
+		Header{Key: HeaderDeadLetterOffset, Value: []byte(strconv.FormatInt(origin.Offset, 10))},
+	)
+
+	err := d.sink.WriteMessages(ctx, Message{
+		Key:     msg.Key,
+		Value:   msg.Value,
+		Headers: headers,
+	})
+	if err != nil {
+		return fmt.Errorf("write dead letter: %v", err)
+	}
+
+	return nil
+}
+
+func (d *DeadLetter) Close() error {
+	return d.sink.Close()
+}
+
+// DeadLetterRecord describes why and where from a message was dead-lettered.
+type DeadLetterRecord struct {
+	Stage     string
+	Error     string
+	Attempts  int
+	Topic     string
+	Partition int
+	Offset    int64
+}
+
+func ParseDeadLetter(msg Message) (DeadLetterRecord, error) {
+	var record DeadLetterRecord
+	var ok bool
+
+	record.Stage, _ = msg.Headers.Get(HeaderDeadLetterStage)
+	record.Error, _ = msg.Headers.Get(HeaderDeadLetterError)
+	record.Topic, ok = msg.Headers.Get(HeaderDeadLetterTopic)
+	if !ok {
+		return record, fmt.Errorf("missing %s header", HeaderDeadLetterTopic)
+	}
+
+	attempts, _ := msg.Headers.Get(HeaderDeadLetterAttempts)
+	record.Attempts, _ = strconv.Atoi(attempts)
+
+	partition, _ := msg.Headers.Get(HeaderDeadLetterPartition)
+	record.Partition, _ = strconv.Atoi(partition)
+
+	offset, _ := msg.Headers.Get(HeaderDeadLetterOffset)
+	record.Offset, _ = strconv.ParseInt(offset, 10, 64)
+
+	return record, nil
+}
+
+// Reinjection builds the message that puts a dead-lettered message back on
+// the topic it was originally consumed from.
+func Reinjection(msg Message) (Message, error) {
+	record, err := ParseDeadLetter(msg)
+	if err != nil {
+		return Message{}, err
+	}
+
+	return Message{
+		Topic:   record.Topic,
+		Key:     msg.Key,
+		Value:   msg.Value,
+		Headers: msg.Headers.Without(deadLetterHeaders...),
+	}, nil
+}
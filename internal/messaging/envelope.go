@@ -0,0 +1,48 @@
+package messaging
+
+import (
+	"log"
+
+	"github.com/Bitstarz-eng/event-processing-challenge/internal/casino"
+)
+
+// Envelope carries a decoded event through a stage together with the message
+// it was read from, so that the message is only committed once the stage has
+// finished with the event. Headers are passed on to the next stage when the
+// event is published.
+type Envelope struct {
+	Event   casino.Event
+	Headers Headers
+	msg     Message
+	acker   acker
+}
+
+type acker interface {
+	ack(msg Message)
+	fail(msg Message, err error)
+}
+
+// NewEnvelope wraps an event that was not read from a Source, such as a
+// freshly generated one. Acknowledging it is a no-op.
+func NewEnvelope(event casino.Event) Envelope {
+	return Envelope{
+		Event: event,
+	}
+}
+
+// Ack marks the event as handled, allowing its message to be committed.
+func (e Envelope) Ack() {
+	if e.acker != nil {
+		e.acker.ack(e.msg)
+	}
+}
+
+// Fail hands the event over to the consumer's failure handling instead of
+// passing it on, and acknowledges it once that is done.
+func (e Envelope) Fail(err error) {
+	if e.acker == nil {
+		log.Printf("dropping event %d: %v", e.Event.ID, err)
+		return
+	}
+	e.acker.fail(e.msg, err)
+}
@@ -0,0 +1,147 @@
+package messaging
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Bitstarz-eng/event-processing-challenge/internal/casino"
+)
+
+const (
+	// HeaderStage names the stage that produced the message.
+	HeaderStage = "x-stage"
+	// HeaderSchemaVersion is the casino.Event schema version of the payload.
+	HeaderSchemaVersion = "x-schema-version"
+	// HeaderProducedAt is when the stage produced the message.
+	HeaderProducedAt = "x-produced-at"
+	// HeaderHop is added once per stage as "<stage>@<produced at>", recording
+	// the path the event took through the pipeline.
+	HeaderHop = "x-hop"
+	// HeaderTraceParent carries the W3C trace context. Its trace ID doubles as
+	// the correlation ID of the event across stages.
+	HeaderTraceParent = "traceparent"
+	// HeaderReplay is set on replayed messages to "<topic>/<partition>/<offset>"
+	// of the message they replay. It is passed on by every stage, which
+	// tells replayed events apart from the originals by it.
+	HeaderReplay = "x-replay"
+)
+
+type Headers []Header
+
+// Get returns the value of the last header with the given key.
+func (h Headers) Get(key string) (string, bool) {
+	for i := len(h) - 1; i >= 0; i-- {
+		if h[i].Key == key {
+			return string(h[i].Value), true
+		}
+	}
+
+	return "", false
+}
+
+// Values returns the values of all headers with the given key, in order.
+func (h Headers) Values(key string) []string {
+	var values []string
+	for _, header := range h {
+		if header.Key == key {
+			values = append(values, string(header.Value))
+		}
+	}
+
+	return values
+}
+
+// Set replaces all headers with the given key by a single one.
+func (h *Headers) Set(key, value string) {
+	*h = h.Without(key)
+	h.Add(key, value)
+}
+
+func (h *Headers) Add(key, value string) {
+	*h = append(*h, Header{Key: key, Value: []byte(value)})
+}
+
+// Without returns a copy of the headers without those with the given keys.
+func (h Headers) Without(keys ...string) Headers {
+	result := make(Headers, 0, len(h))
+	for _, header := range h {
+		drop := false
+		for _, key := range keys {
+			if header.Key == key {
+				drop = true
+				break
+			}
+		}
+		if !drop {
+			result = append(result, header)
+		}
+	}
+
+	return result
+}
+
+// TraceID returns the trace ID of the W3C trace context, if any.
+func (h Headers) TraceID() (string, bool) {
+	traceParent, ok := h.Get(HeaderTraceParent)
+	if !ok {
+		return "", false
+	}
+
+	parts := strings.Split(traceParent, "-")
+	if len(parts) != 4 || len(parts[1]) != 32 {
+		return "", false
+	}
+	return parts[1], true
+}
+
+// ProducedAt returns when the previous stage produced the message.
+func (h Headers) ProducedAt() (time.Time, bool) {
+	value, ok := h.Get(HeaderProducedAt)
+	if !ok {
+		return time.Time{}, false
+	}
+
+	producedAt, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return producedAt, true
+}
+
+// stamp records that stage produced the message now, continuing the trace of
+// the incoming headers or starting a new one.
+func (h *Headers) stamp(stage string, now time.Time) error {
+	traceID, ok := h.TraceID()
+	if !ok {
+		id, err := randomHex(16)
+		if err != nil {
+			return err
+		}
+		traceID = id
+	}
+	spanID, err := randomHex(8)
+	if err != nil {
+		return err
+	}
+
+	producedAt := now.UTC().Format(time.RFC3339Nano)
+	h.Set(HeaderTraceParent, fmt.Sprintf("00-%s-%s-01", traceID, spanID))
+	h.Set(HeaderStage, stage)
+	h.Set(HeaderSchemaVersion, casino.EventSchemaVersion)
+	h.Set(HeaderProducedAt, producedAt)
+	h.Add(HeaderHop, stage+"@"+producedAt)
+	return nil
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", fmt.Errorf("generate trace context: %v", err)
+	}
+
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,174 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Bitstarz-eng/event-processing-challenge/internal/config"
+
+	kafka "github.com/segmentio/kafka-go"
+)
+
+// NewKafkaWriter creates a synchronous writer for topic that batches, compresses
+// and acknowledges writes as configured and partitions messages by key.
+func NewKafkaWriter(brokers, topic string, publishCfg config.Publish) (*kafka.Writer, error) {
+	var compression kafka.Compression
+	err := compression.UnmarshalText([]byte(publishCfg.Compression))
+	if err != nil {
+		return nil, fmt.Errorf("compression: %v", err)
+	}
+
+	var requiredAcks kafka.RequiredAcks
+	err = requiredAcks.UnmarshalText([]byte(publishCfg.RequiredAcks))
+	if err != nil {
+		return nil, fmt.Errorf("required acks: %v", err)
+	}
+
+	return &kafka.Writer{
+		Addr:         kafka.TCP(strings.Split(brokers, ",")...),
+		Topic:        topic,
+		Balancer:     &kafka.Hash{},
+		BatchSize:    publishCfg.BatchSize,
+		BatchTimeout: publishCfg.Linger,
+		Compression:  compression,
+		RequiredAcks: requiredAcks,
+	}, nil
+}
+
+type KafkaSource struct {
+	reader *kafka.Reader
+}
+
+func NewKafkaSource(reader *kafka.Reader) *KafkaSource {
+	return &KafkaSource{
+		reader: reader,
+	}
+}
+
+func (s *KafkaSource) FetchMessage(ctx context.Context) (Message, error) {
+	m, err := s.reader.FetchMessage(ctx)
+	if err != nil {
+		return Message{}, err
+	}
+
+	return fromKafkaMessage(m), nil
+}
+
+func (s *KafkaSource) CommitMessages(ctx context.Context, msgs ...Message) error {
+	kafkaMsgs := make([]kafka.Message, len(msgs))
+	for i, msg := range msgs {
+		kafkaMsgs[i] = toKafkaMessage(msg)
+	}
+
+	return s.reader.CommitMessages(ctx, kafkaMsgs...)
+}
+
+func (s *KafkaSource) Close() error {
+	return s.reader.Close()
+}
+
+func (s *KafkaSource) endOffsets(ctx context.Context, partitions map[string][]int) (map[topicPartition]int64, error) {
+	client := &kafka.Client{
+		Addr: kafka.TCP(s.reader.Config().Brokers...),
+	}
+
+	return kafkaEndOffsets(ctx, client, partitions)
+}
+
+func kafkaEndOffsets(ctx context.Context, client *kafka.Client, partitions map[string][]int) (map[topicPartition]int64, error) {
+	req := &kafka.ListOffsetsRequest{Topics: make(map[string][]kafka.OffsetRequest, len(partitions))}
+	for topic, ids := range partitions {
+		for _, id := range ids {
+			req.Topics[topic] = append(req.Topics[topic], kafka.LastOffsetOf(id))
+		}
+	}
+
+	resp, err := client.ListOffsets(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("list offsets: %v", err)
+	}
+
+	offsets := make(map[topicPartition]int64)
+	for topic, partitionOffsets := range resp.Topics {
+		for _, partition := range partitionOffsets {
+			if partition.Error != nil {
+				return nil, fmt.Errorf("list offsets of %s/%d: %v", topic, partition.Partition, partition.Error)
+			}
+			offsets[topicPartition{topic: topic, partition: partition.Partition}] = partition.LastOffset
+		}
+	}
+	return offsets, nil
+}
+
+type KafkaSink struct {
+	writer *kafka.Writer
+}
+
+func NewKafkaSink(writer *kafka.Writer) *KafkaSink {
+	return &KafkaSink{
+		writer: writer,
+	}
+}
+
+func (s *KafkaSink) WriteMessages(ctx context.Context, msgs ...Message) error {
+	kafkaMsgs := make([]kafka.Message, len(msgs))
+	for i, msg := range msgs {
+		kafkaMsgs[i] = toKafkaMessage(msg)
+		// The writer rejects messages that set a topic when it has one configured.
+		if s.writer.Topic != "" {
+			kafkaMsgs[i].Topic = ""
+		}
+		kafkaMsgs[i].Partition = 0
+		kafkaMsgs[i].Offset = 0
+	}
+
+	err := s.writer.WriteMessages(ctx, kafkaMsgs...)
+
+	var kafkaErrs kafka.WriteErrors
+	if errors.As(err, &kafkaErrs) {
+		return WriteErrors(kafkaErrs)
+	}
+	return err
+}
+
+func (s *KafkaSink) Close() error {
+	return s.writer.Close()
+}
+
+func fromKafkaMessage(m kafka.Message) Message {
+	headers := make(Headers, len(m.Headers))
+	for i, h := range m.Headers {
+		headers[i] = Header{Key: h.Key, Value: h.Value}
+	}
+
+	return Message{
+		Topic:         m.Topic,
+		Partition:     m.Partition,
+		Offset:        m.Offset,
+		HighWaterMark: m.HighWaterMark,
+		Key:           m.Key,
+		Value:         m.Value,
+		Headers:       headers,
+		Time:          m.Time,
+	}
+}
+
+func toKafkaMessage(m Message) kafka.Message {
+	headers := make([]kafka.Header, len(m.Headers))
+	for i, h := range m.Headers {
+		headers[i] = kafka.Header{Key: h.Key, Value: h.Value}
+	}
+
+	return kafka.Message{
+		Topic:         m.Topic,
+		Partition:     m.Partition,
+		Offset:        m.Offset,
+		HighWaterMark: m.HighWaterMark,
+		Key:           m.Key,
+		Value:         m.Value,
+		Headers:       headers,
+		Time:          m.Time,
+	}
+}
@@ -0,0 +1,41 @@
+package messaging
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/Bitstarz-eng/event-processing-challenge/internal/casino"
+)
+
+// KeyStrategy derives the message key, and with it the partition, of an event.
+// Events with the same key keep their relative order.
+type KeyStrategy func(event casino.Event) []byte
+
+// KeyByPlayerID keeps all events of a player in order on one partition.
+func KeyByPlayerID(event casino.Event) []byte {
+	return []byte(strconv.Itoa(event.PlayerID))
+}
+
+// KeyByGameID keeps all events of a game in order on one partition. Deposits
+// carry no game and therefore all share a partition.
+func KeyByGameID(event casino.Event) []byte {
+	return []byte(strconv.Itoa(event.GameID))
+}
+
+// KeyByEventID spreads events evenly without any ordering guarantee.
+func KeyByEventID(event casino.Event) []byte {
+	return []byte(strconv.Itoa(event.ID))
+}
+
+func ParseKeyStrategy(name string) (KeyStrategy, error) {
+	switch name {
+	case "player_id":
+		return KeyByPlayerID, nil
+	case "game_id":
+		return KeyByGameID, nil
+	case "event_id":
+		return KeyByEventID, nil
+	default:
+		return nil, fmt.Errorf("unknown key strategy %q", name)
+	}
+}
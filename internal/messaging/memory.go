@@ -0,0 +1,273 @@
+package messaging
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"sync"
+	"time"
+)
+
+const defaultMemoryPartitions = 1
+
+// Broker is an in-process message broker with partitioned topics, consumer
+// groups and committed offsets. Members of the same group share the group's
+// read position, so each message is handed to exactly one of them.
+type Broker struct {
+	mu     sync.Mutex
+	topics map[string]*memoryTopic
+	groups int
+}
+
+type memoryTopic struct {
+	partitions [][]Message
+	groups     map[string]*memoryGroup
+	nextWrite  int
+	notify     chan struct{}
+}
+
+type memoryGroup struct {
+	next      []int64
+	committed []int64
+	members   int
+	nextRead  int
+}
+
+func NewBroker() *Broker {
+	return &Broker{
+		topics: make(map[string]*memoryTopic),
+	}
+}
+
+// CreateTopic creates a topic with the given number of partitions. Topics that
+// are used without being created first get a single partition.
+func (b *Broker) CreateTopic(name string, partitions int) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if partitions < 1 {
+		return fmt.Errorf("invalid partition count %d for topic %s", partitions, name)
+	}
+	if _, ok := b.topics[name]; ok {
+		return fmt.Errorf("topic %s already exists", name)
+	}
+
+	b.topics[name] = newMemoryTopic(partitions)
+	return nil
+}
+
+// Partitions returns the partition count of a topic, if it exists.
+func (b *Broker) Partitions(name string) (int, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	t, ok := b.topics[name]
+	if !ok {
+		return 0, false
+	}
+	return len(t.partitions), true
+}
+
+func (b *Broker) Source(topic, group string) *MemorySource {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if group == "" {
+		b.groups++
+		group = fmt.Sprintf("anonymous-%d", b.groups)
+	}
+
+	t := b.topic(topic)
+	g, ok := t.groups[group]
+	if !ok {
+		g = &memoryGroup{
+			next:      make([]int64, len(t.partitions)),
+			committed: make([]int64, len(t.partitions)),
+		}
+		t.groups[group] = g
+	}
+	g.members++
+
+	return &MemorySource{
+		broker: b,
+		topic:  topic,
+		group:  group,
+		done:   make(chan struct{}),
+	}
+}
+
+func (b *Broker) Sink(topic string) *MemorySink {
+	return &MemorySink{
+		broker: b,
+		topic:  topic,
+	}
+}
+
+// topic must be called with b.mu held.
+func (b *Broker) topic(name string) *memoryTopic {
+	t, ok := b.topics[name]
+	if !ok {
+		t = newMemoryTopic(defaultMemoryPartitions)
+		b.topics[name] = t
+	}
+
+	return t
+}
+
+func newMemoryTopic(partitions int) *memoryTopic {
+	return &memoryTopic{
+		partitions: make([][]Message, partitions),
+		groups:     make(map[string]*memoryGroup),
+		notify:     make(chan struct{}),
+	}
+}
+
+// partition picks a partition by key hash, falling back to round-robin for
+// messages without a key.
+func (t *memoryTopic) partition(key []byte) int {
+	if len(key) == 0 {
+		p := t.nextWrite % len(t.partitions)
+		t.nextWrite++
+		return p
+	}
+
+	h := fnv.New32a()
+	h.Write(key)
+	return int(h.Sum32() % uint32(len(t.partitions)))
+}
+
+type MemorySource struct {
+	broker *Broker
+	topic  string
+	group  string
+	closed bool
+	done   chan struct{}
+}
+
+func (s *MemorySource) FetchMessage(ctx context.Context) (Message, error) {
+	for {
+		s.broker.mu.Lock()
+		if s.closed {
+			s.broker.mu.Unlock()
+			return Message{}, io.EOF
+		}
+
+		t := s.broker.topic(s.topic)
+		g := t.groups[s.group]
+		for i := 0; i < len(t.partitions); i++ {
+			p := (g.nextRead + i) % len(t.partitions)
+			if g.next[p] < int64(len(t.partitions[p])) {
+				msg := t.partitions[p][g.next[p]]
+				msg.HighWaterMark = int64(len(t.partitions[p]))
+				g.next[p]++
+				g.nextRead = p + 1
+				s.broker.mu.Unlock()
+				return msg, nil
+			}
+		}
+		notify := t.notify
+		s.broker.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return Message{}, ctx.Err()
+		case <-s.done:
+			return Message{}, io.EOF
+		case <-notify:
+		}
+	}
+}
+
+func (s *MemorySource) CommitMessages(ctx context.Context, msgs ...Message) error {
+	s.broker.mu.Lock()
+	defer s.broker.mu.Unlock()
+
+	g := s.broker.topic(s.topic).groups[s.group]
+	for _, msg := range msgs {
+		if msg.Partition < 0 || msg.Partition >= len(g.committed) {
+			return fmt.Errorf("commit unknown partition %d of topic %s", msg.Partition, s.topic)
+		}
+		if msg.Offset+1 > g.committed[msg.Partition] {
+			g.committed[msg.Partition] = msg.Offset + 1
+		}
+	}
+
+	return nil
+}
+
+func (s *MemorySource) endOffsets(ctx context.Context, partitions map[string][]int) (map[topicPartition]int64, error) {
+	s.broker.mu.Lock()
+	defer s.broker.mu.Unlock()
+
+	offsets := make(map[topicPartition]int64)
+	for topic, ids := range partitions {
+		t := s.broker.topic(topic)
+		for _, id := range ids {
+			if id >= 0 && id < len(t.partitions) {
+				offsets[topicPartition{topic: topic, partition: id}] = int64(len(t.partitions[id]))
+			}
+		}
+	}
+	return offsets, nil
+}
+
+// Close leaves the group. Once the last member has left, uncommitted messages
+// are handed out again to the next member that joins.
+func (s *MemorySource) Close() error {
+	s.broker.mu.Lock()
+	defer s.broker.mu.Unlock()
+
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	close(s.done)
+
+	g := s.broker.topic(s.topic).groups[s.group]
+	g.members--
+	if g.members == 0 {
+		copy(g.next, g.committed)
+	}
+
+	return nil
+}
+
+type MemorySink struct {
+	broker *Broker
+	topic  string
+}
+
+func (s *MemorySink) WriteMessages(ctx context.Context, msgs ...Message) error {
+	s.broker.mu.Lock()
+	defer s.broker.mu.Unlock()
+
+	for _, msg := range msgs {
+		topic := s.topic
+		if topic == "" {
+			topic = msg.Topic
+		}
+		if topic == "" {
+			return fmt.Errorf("write message: no topic")
+		}
+
+		t := s.broker.topic(topic)
+		p := t.partition(msg.Key)
+		msg.Topic = topic
+		msg.Partition = p
+		msg.Offset = int64(len(t.partitions[p]))
+		if msg.Time.IsZero() {
+			msg.Time = time.Now()
+		}
+		t.partitions[p] = append(t.partitions[p], msg)
+
+		close(t.notify)
+		t.notify = make(chan struct{})
+	}
+
+	return nil
+}
+
+func (s *MemorySink) Close() error {
+	return nil
+}
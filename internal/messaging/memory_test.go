@@ -0,0 +1,31 @@
+package messaging
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestMemorySourceCloseWakesFetch(t *testing.T) {
+	source := NewBroker().Source("events", "group")
+
+	fetched := make(chan error, 1)
+	go func() {
+		_, err := source.FetchMessage(context.Background())
+		fetched <- err
+	}()
+
+	// Give the fetch time to block on the empty topic.
+	time.Sleep(10 * time.Millisecond)
+	source.Close()
+
+	select {
+	case err := <-fetched:
+		if err != io.EOF {
+			t.Errorf("got %v from a closed source, want io.EOF", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("fetch still blocked after the source was closed")
+	}
+}
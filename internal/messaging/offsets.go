@@ -0,0 +1,59 @@
+package messaging
+
+import (
+	"sync"
+)
+
+type topicPartition struct {
+	topic     string
+	partition int
+}
+
+// offsetTracker records fetched messages per partition and works out how far
+// each partition can be committed once messages are acknowledged out of order.
+type offsetTracker struct {
+	mu      sync.Mutex
+	pending map[topicPartition][]Message
+	acked   map[topicPartition]map[int64]bool
+}
+
+func newOffsetTracker() *offsetTracker {
+	return &offsetTracker{
+		pending: make(map[topicPartition][]Message),
+		acked:   make(map[topicPartition]map[int64]bool),
+	}
+}
+
+func (t *offsetTracker) fetched(msg Message) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	tp := topicPartition{topic: msg.Topic, partition: msg.Partition}
+	t.pending[tp] = append(t.pending[tp], msg)
+}
+
+// ack returns the last message of the contiguous acknowledged prefix of the
+// partition, if acknowledging msg extended it.
+func (t *offsetTracker) ack(msg Message) (Message, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	tp := topicPartition{topic: msg.Topic, partition: msg.Partition}
+	if t.acked[tp] == nil {
+		t.acked[tp] = make(map[int64]bool)
+	}
+	t.acked[tp][msg.Offset] = true
+
+	var last Message
+	advanced := false
+	pending := t.pending[tp]
+	for len(pending) > 0 && t.acked[tp][pending[0].Offset] {
+		last = pending[0]
+		advanced = true
+		delete(t.acked[tp], pending[0].Offset)
+		pending = pending[1:]
+	}
+	t.pending[tp] = pending
+
+	return last, advanced
+}
@@ -0,0 +1,380 @@
+package messaging
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+
+	kafka "github.com/segmentio/kafka-go"
+)
+
+// OffsetStore keeps the position of a consumer group in the processed_offsets
+// table, next to the output the group's stage writes to Postgres.
+type OffsetStore struct {
+	db    *sql.DB
+	group string
+}
+
+func NewOffsetStore(db *sql.DB, group string) *OffsetStore {
+	return &OffsetStore{
+		db:    db,
+		group: group,
+	}
+}
+
+// Offsets returns the next offset to read of every partition of topic the
+// group has written output for.
+func (s *OffsetStore) Offsets(ctx context.Context, topic string) (map[int]int64, error) {
+	query := `SELECT partition, next_offset FROM processed_offsets WHERE consumer_group = $1 AND topic = $2`
+	rows, err := s.db.QueryContext(ctx, query, s.group, topic)
+	if err != nil {
+		return nil, fmt.Errorf("querying processed offsets: %v", err)
+	}
+	defer rows.Close()
+
+	offsets := make(map[int]int64)
+	for rows.Next() {
+		var partition int
+		var nextOffset int64
+		err := rows.Scan(&partition, &nextOffset)
+		if err != nil {
+			return nil, fmt.Errorf("scanning processed offset: %v", err)
+		}
+		offsets[partition] = nextOffset
+	}
+
+	return offsets, rows.Err()
+}
+
+// nextOffset returns the stored next offset of the message's partition,
+// locking it until the transaction ends. It is -1 when nothing is stored.
+func (s *OffsetStore) nextOffset(ctx context.Context, tx *sql.Tx, msg Message) (int64, error) {
+	query := `SELECT next_offset FROM processed_offsets WHERE consumer_group = $1 AND topic = $2 AND partition = $3 FOR UPDATE`
+	row := tx.QueryRowContext(ctx, query, s.group, msg.Topic, msg.Partition)
+
+	var nextOffset int64
+	err := row.Scan(&nextOffset)
+	if err == sql.ErrNoRows {
+		return -1, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("scanning processed offset: %v", err)
+	}
+	return nextOffset, nil
+}
+
+func (s *OffsetStore) store(ctx context.Context, tx *sql.Tx, msg Message) error {
+	query := `INSERT INTO processed_offsets (consumer_group, topic, partition, next_offset)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (consumer_group, topic, partition)
+		DO UPDATE SET next_offset = EXCLUDED.next_offset, updated_at = now()`
+	_, err := tx.ExecContext(ctx, query, s.group, msg.Topic, msg.Partition, msg.Offset+1)
+	if err != nil {
+		return fmt.Errorf("storing processed offset: %v", err)
+	}
+	return nil
+}
+
+// TxWriter writes the output of an event within a transaction.
+type TxWriter func(ctx context.Context, tx *sql.Tx, envelope Envelope) error
+
+// TransactionalSink writes the output of events together with the offsets
+// they were consumed from. Events are held back once they are acknowledged and
+// written when their offset is committed, in one transaction per commit, so
+// that the output and the offset are stored together and in the order the
+// events were consumed, however they were acknowledged. Events before the
+// stored offset were already written and are skipped, so redelivered events
+// have no effect.
+type TransactionalSink struct {
+	db      *sql.DB
+	offsets *OffsetStore
+	write   TxWriter
+
+	mu     sync.Mutex
+	staged map[topicPartition][]Envelope
+}
+
+func NewTransactionalSink(db *sql.DB, offsets *OffsetStore, write TxWriter) *TransactionalSink {
+	return &TransactionalSink{
+		db:      db,
+		offsets: offsets,
+		write:   write,
+		staged:  make(map[topicPartition][]Envelope),
+	}
+}
+
+// Source commits the offsets of source through the sink, before committing
+// them to source itself.
+func (s *TransactionalSink) Source(source Source) Source {
+	return &transactionalSource{
+		Source: source,
+		sink:   s,
+	}
+}
+
+// Process passes envelopes on, so that the output of each one is written once
+// it is acknowledged. Events that fail have no output.
+func (s *TransactionalSink) Process(consumeCh <-chan Envelope, publishCh chan<- Envelope) {
+	defer close(publishCh)
+
+	for envelope := range consumeCh {
+		if envelope.acker != nil {
+			envelope.acker = &stagingAcker{
+				sink:     s,
+				envelope: envelope,
+				next:     envelope.acker,
+			}
+		}
+		publishCh <- envelope
+	}
+}
+
+func (s *TransactionalSink) stage(envelope Envelope) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tp := topicPartition{topic: envelope.msg.Topic, partition: envelope.msg.Partition}
+	s.staged[tp] = append(s.staged[tp], envelope)
+}
+
+// unstage takes the envelopes staged up to and including msg, in the order
+// they were consumed.
+func (s *TransactionalSink) unstage(msg Message) []Envelope {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tp := topicPartition{topic: msg.Topic, partition: msg.Partition}
+	var taken, kept []Envelope
+	for _, envelope := range s.staged[tp] {
+		if envelope.msg.Offset <= msg.Offset {
+			taken = append(taken, envelope)
+		} else {
+			kept = append(kept, envelope)
+		}
+	}
+	s.staged[tp] = kept
+
+	sort.Slice(taken, func(i, j int) bool {
+		return taken[i].msg.Offset < taken[j].msg.Offset
+	})
+	return taken
+}
+
+// commit writes the output of the events staged up to msg and stores the
+// offset after msg in one transaction. The events stay staged if it fails.
+func (s *TransactionalSink) commit(ctx context.Context, msg Message) error {
+	envelopes := s.unstage(msg)
+	err := s.commitTx(ctx, msg, envelopes)
+	if err != nil {
+		for _, envelope := range envelopes {
+			s.stage(envelope)
+		}
+	}
+
+	return err
+}
+
+func (s *TransactionalSink) commitTx(ctx context.Context, msg Message, envelopes []Envelope) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("beginning transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	nextOffset, err := s.offsets.nextOffset(ctx, tx, msg)
+	if err != nil {
+		return err
+	}
+	if msg.Offset < nextOffset {
+		log.Printf("Skipping offsets up to %d of %s/%d, already written", msg.Offset, msg.Topic, msg.Partition)
+		return nil
+	}
+
+	for _, envelope := range envelopes {
+		if envelope.msg.Offset < nextOffset {
+			continue
+		}
+		err = s.write(ctx, tx, envelope)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = s.offsets.store(ctx, tx, msg)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("committing transaction: %v", err)
+	}
+	return nil
+}
+
+// stagingAcker stages an envelope with the sink before acknowledging it, so
+// that it is staged before its offset can be committed.
+type stagingAcker struct {
+	sink     *TransactionalSink
+	envelope Envelope
+	next     acker
+}
+
+func (a *stagingAcker) ack(msg Message) {
+	a.sink.stage(a.envelope)
+	a.next.ack(msg)
+}
+
+func (a *stagingAcker) fail(msg Message, err error) {
+	a.next.fail(msg, err)
+}
+
+type transactionalSource struct {
+	Source
+	sink *TransactionalSink
+}
+
+func (s *transactionalSource) CommitMessages(ctx context.Context, msgs ...Message) error {
+	for _, msg := range msgs {
+		err := s.sink.commit(ctx, msg)
+		if err != nil {
+			return err
+		}
+	}
+
+	return s.Source.CommitMessages(ctx, msgs...)
+}
+
+func (s *transactionalSource) endOffsets(ctx context.Context, partitions map[string][]int) (map[topicPartition]int64, error) {
+	lister, ok := s.Source.(endOffsetLister)
+	if !ok {
+		return nil, errors.New("source cannot look up end offsets")
+	}
+	return lister.endOffsets(ctx, partitions)
+}
+
+// KafkaPartitionSource reads every partition of a topic from offsets stored
+// outside of Kafka instead of from a consumer group. Since partitions are not
+// balanced between instances, a stage reading it must run as one instance.
+// Committing is left to whoever stores the offsets.
+type KafkaPartitionSource struct {
+	client  *kafka.Client
+	readers []*kafka.Reader
+	msgCh   chan Message
+	errCh   chan error
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+// NewKafkaPartitionSource starts reading every partition of topic at the
+// offset stored for it, or at the first offset when none is stored.
+func NewKafkaPartitionSource(ctx context.Context, brokers, topic string, offsets map[int]int64) (*KafkaPartitionSource, error) {
+	client := &kafka.Client{
+		Addr: kafka.TCP(strings.Split(brokers, ",")...),
+	}
+	metadata, err := client.Metadata(ctx, &kafka.MetadataRequest{Topics: []string{topic}})
+	if err != nil {
+		return nil, fmt.Errorf("metadata: %v", err)
+	}
+	if len(metadata.Topics) != 1 || metadata.Topics[0].Error != nil {
+		return nil, fmt.Errorf("topic %s not found", topic)
+	}
+
+	readCtx, cancel := context.WithCancel(context.Background())
+	s := &KafkaPartitionSource{
+		client: client,
+		msgCh:  make(chan Message),
+		errCh:  make(chan error),
+		cancel: cancel,
+	}
+
+	for _, partition := range metadata.Topics[0].Partitions {
+		reader := kafka.NewReader(kafka.ReaderConfig{
+			Brokers:   strings.Split(brokers, ","),
+			Topic:     topic,
+			Partition: partition.ID,
+		})
+
+		offset, ok := offsets[partition.ID]
+		if !ok {
+			offset = kafka.FirstOffset
+		}
+		err := reader.SetOffset(offset)
+		if err != nil {
+			reader.Close()
+			s.Close()
+			return nil, fmt.Errorf("seek partition %d: %v", partition.ID, err)
+		}
+		log.Printf("Reading %s/%d from offset %d", topic, partition.ID, offset)
+
+		s.readers = append(s.readers, reader)
+		s.wg.Add(1)
+		go s.read(readCtx, reader)
+	}
+
+	return s, nil
+}
+
+func (s *KafkaPartitionSource) read(ctx context.Context, reader *kafka.Reader) {
+	defer s.wg.Done()
+
+	for {
+		m, err := reader.FetchMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			select {
+			case s.errCh <- err:
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		select {
+		case s.msgCh <- fromKafkaMessage(m):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *KafkaPartitionSource) FetchMessage(ctx context.Context) (Message, error) {
+	select {
+	case <-ctx.Done():
+		return Message{}, ctx.Err()
+	case err := <-s.errCh:
+		return Message{}, err
+	case msg := <-s.msgCh:
+		return msg, nil
+	}
+}
+
+func (s *KafkaPartitionSource) CommitMessages(ctx context.Context, msgs ...Message) error {
+	return nil
+}
+
+func (s *KafkaPartitionSource) endOffsets(ctx context.Context, partitions map[string][]int) (map[topicPartition]int64, error) {
+	return kafkaEndOffsets(ctx, s.client, partitions)
+}
+
+func (s *KafkaPartitionSource) Close() error {
+	s.cancel()
+	s.wg.Wait()
+
+	var firstErr error
+	for _, reader := range s.readers {
+		err := reader.Close()
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
@@ -0,0 +1,236 @@
+package messaging
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func init() {
+	sql.Register("offsets", &offsetsDriver{})
+}
+
+func TestTransactionalSink(t *testing.T) {
+	broker := NewBroker()
+	for id := 1; id <= 4; id++ {
+		err := broker.Sink("events").WriteMessages(context.Background(), Message{Value: []byte(fmt.Sprintf(`{"id":%d}`, id))})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	db, err := sql.Open("offsets", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var outputs []int
+	write := func(ctx context.Context, tx *sql.Tx, envelope Envelope) error {
+		_, err := tx.ExecContext(ctx, `INSERT INTO outputs (event_id) VALUES ($1)`, envelope.Event.ID)
+		return err
+	}
+	offsets := NewOffsetStore(db, "group")
+
+	// consume hands the events of group to a new sink, and handles them with
+	// handle once they passed it.
+	consume := func(group string, handle func(envelopes []Envelope)) {
+		sink := NewTransactionalSink(db, offsets, write)
+		consumeCh := make(chan Envelope, 4)
+		consumer := NewConsumer(context.Background(), sink.Source(broker.Source("events", group)), consumeCh)
+		for i := 0; i < 4; i++ {
+			err := consumer.consumeMessage()
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		close(consumeCh)
+
+		publishCh := make(chan Envelope, 4)
+		sink.Process(consumeCh, publishCh)
+		var envelopes []Envelope
+		for envelope := range publishCh {
+			envelopes = append(envelopes, envelope)
+		}
+
+		handle(envelopes)
+		consumer.Close()
+		outputs = db.Driver().(*offsetsDriver).outputs()
+	}
+
+	// Acknowledged out of order, with the last event still in progress.
+	consume("group", func(envelopes []Envelope) {
+		envelopes[2].Ack()
+		envelopes[1].Fail(errors.New("no output"))
+		envelopes[0].Ack()
+	})
+	if want := []int{1, 3}; !reflect.DeepEqual(outputs, want) {
+		t.Errorf("wrote events %v, want %v", outputs, want)
+	}
+	stored, err := offsets.Offsets(context.Background(), "events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[int]int64{0: 3}; !reflect.DeepEqual(stored, want) {
+		t.Errorf("stored offsets %v, want %v", stored, want)
+	}
+
+	// Delivered again from the start, only the last event is new.
+	consume("fresh", func(envelopes []Envelope) {
+		for _, envelope := range envelopes {
+			envelope.Ack()
+		}
+	})
+	if want := []int{1, 3, 4}; !reflect.DeepEqual(outputs, want) {
+		t.Errorf("wrote events %v after redelivery, want %v", outputs, want)
+	}
+	stored, err = offsets.Offsets(context.Background(), "events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[int]int64{0: 4}; !reflect.DeepEqual(stored, want) {
+		t.Errorf("stored offsets %v after redelivery, want %v", stored, want)
+	}
+}
+
+// offsetsDriver keeps processed_offsets and the event IDs written to outputs
+// in memory, applying the writes of a transaction when it is committed.
+type offsetsDriver struct {
+	mu      sync.Mutex
+	offsets map[string]int64
+	written []int
+}
+
+func (d *offsetsDriver) Open(name string) (driver.Conn, error) {
+	return &offsetsConn{driver: d}, nil
+}
+
+func (d *offsetsDriver) outputs() []int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return append([]int(nil), d.written...)
+}
+
+type offsetsConn struct {
+	driver  *offsetsDriver
+	offsets map[string]int64
+	written []int
+}
+
+func (c *offsetsConn) Prepare(query string) (driver.Stmt, error) {
+	return &offsetsStmt{conn: c, query: query}, nil
+}
+
+func (c *offsetsConn) Close() error {
+	return nil
+}
+
+func (c *offsetsConn) Begin() (driver.Tx, error) {
+	c.offsets = make(map[string]int64)
+	c.written = nil
+	return c, nil
+}
+
+func (c *offsetsConn) Commit() error {
+	c.driver.mu.Lock()
+	defer c.driver.mu.Unlock()
+
+	if c.driver.offsets == nil {
+		c.driver.offsets = make(map[string]int64)
+	}
+	for key, offset := range c.offsets {
+		c.driver.offsets[key] = offset
+	}
+	c.driver.written = append(c.driver.written, c.written...)
+	return nil
+}
+
+func (c *offsetsConn) Rollback() error {
+	return nil
+}
+
+type offsetsStmt struct {
+	conn  *offsetsConn
+	query string
+}
+
+func (s *offsetsStmt) Close() error {
+	return nil
+}
+
+func (s *offsetsStmt) NumInput() int {
+	return -1
+}
+
+func (s *offsetsStmt) Exec(args []driver.Value) (driver.Result, error) {
+	switch {
+	case strings.Contains(s.query, "INSERT INTO processed_offsets"):
+		s.conn.offsets[offsetKey(args[0], args[1], args[2])] = args[3].(int64)
+	case strings.Contains(s.query, "INSERT INTO outputs"):
+		s.conn.written = append(s.conn.written, int(args[0].(int64)))
+	default:
+		return nil, errors.New("unexpected query: " + s.query)
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (s *offsetsStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.conn.driver.mu.Lock()
+	defer s.conn.driver.mu.Unlock()
+
+	rows := &offsetsRows{}
+	switch {
+	case strings.Contains(s.query, "FOR UPDATE"):
+		rows.columns = []string{"next_offset"}
+		if offset, ok := s.conn.driver.offsets[offsetKey(args[0], args[1], args[2])]; ok {
+			rows.values = [][]driver.Value{{offset}}
+		}
+	case strings.Contains(s.query, "FROM processed_offsets"):
+		rows.columns = []string{"partition", "next_offset"}
+		prefix := offsetKey(args[0], args[1], "")
+		for key, offset := range s.conn.driver.offsets {
+			if strings.HasPrefix(key, prefix) {
+				var partition int64
+				fmt.Sscan(strings.TrimPrefix(key, prefix), &partition)
+				rows.values = append(rows.values, []driver.Value{partition, offset})
+			}
+		}
+	default:
+		return nil, errors.New("unexpected query: " + s.query)
+	}
+	return rows, nil
+}
+
+func offsetKey(group, topic, partition driver.Value) string {
+	return fmt.Sprintf("%v/%v/%v", group, topic, partition)
+}
+
+type offsetsRows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+func (r *offsetsRows) Columns() []string {
+	return r.columns
+}
+
+func (r *offsetsRows) Close() error {
+	return nil
+}
+
+func (r *offsetsRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
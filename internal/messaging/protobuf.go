@@ -0,0 +1,108 @@
+package messaging
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Bitstarz-eng/event-processing-challenge/internal/casino"
+	"github.com/Bitstarz-eng/event-processing-challenge/internal/casino/casinopb"
+
+	"github.com/shopspring/decimal"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// protobufCodec writes events as the Event message of
+// internal/casino/event.proto, using the code generated from it in casinopb.
+type protobufCodec struct{}
+
+func (protobufCodec) ContentType() string {
+	return ContentTypeProtobuf
+}
+
+func (protobufCodec) Encode(event casino.Event) ([]byte, error) {
+	pb := &casinopb.Event{
+		Id:          int64(event.ID),
+		PlayerId:    int64(event.PlayerID),
+		GameId:      int64(event.GameID),
+		Type:        event.Type,
+		Amount:      int64(event.Amount),
+		Currency:    event.Currency,
+		HasWon:      event.HasWon,
+		CreatedAt:   toTimestamp(event.CreatedAt),
+		AmountEur:   int64(event.AmountEUR),
+		Description: event.Description,
+	}
+	if event.Player != (casino.Player{}) {
+		pb.Player = &casinopb.Player{
+			Email:          event.Player.Email,
+			LastSignedInAt: toTimestamp(event.Player.LastSignedInAt),
+		}
+	}
+	if event.ExchangeRate != nil {
+		pb.ExchangeRate = &casinopb.ExchangeRate{
+			Rate:   event.ExchangeRate.Rate.String(),
+			Source: event.ExchangeRate.Source,
+			AsOf:   toTimestamp(event.ExchangeRate.AsOf),
+			Stale:  event.ExchangeRate.Stale,
+		}
+	}
+
+	return proto.Marshal(pb)
+}
+
+func (protobufCodec) Decode(data []byte) (casino.Event, error) {
+	var pb casinopb.Event
+	err := proto.Unmarshal(data, &pb)
+	if err != nil {
+		return casino.Event{}, err
+	}
+
+	event := casino.Event{
+		ID:          int(pb.Id),
+		PlayerID:    int(pb.PlayerId),
+		GameID:      int(pb.GameId),
+		Type:        pb.Type,
+		Amount:      int(pb.Amount),
+		Currency:    pb.Currency,
+		HasWon:      pb.HasWon,
+		CreatedAt:   fromTimestamp(pb.CreatedAt),
+		AmountEUR:   int(pb.AmountEur),
+		Description: pb.Description,
+	}
+	if pb.Player != nil {
+		event.Player = casino.Player{
+			Email:          pb.Player.Email,
+			LastSignedInAt: fromTimestamp(pb.Player.LastSignedInAt),
+		}
+	}
+	if pb.ExchangeRate != nil {
+		rate, err := decimal.NewFromString(pb.ExchangeRate.Rate)
+		if err != nil {
+			return casino.Event{}, fmt.Errorf("decode exchange rate: %v", err)
+		}
+		event.ExchangeRate = &casino.ExchangeRate{
+			Rate:   rate,
+			Source: pb.ExchangeRate.Source,
+			AsOf:   fromTimestamp(pb.ExchangeRate.AsOf),
+			Stale:  pb.ExchangeRate.Stale,
+		}
+	}
+
+	return event, nil
+}
+
+// toTimestamp leaves out zero times, so that they decode as zero times again.
+func toTimestamp(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
+
+func fromTimestamp(ts *timestamppb.Timestamp) time.Time {
+	if ts == nil {
+		return time.Time{}
+	}
+	return ts.AsTime()
+}
@@ -2,59 +2,249 @@ package messaging
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
-	"strconv"
+	"time"
 
 	"github.com/Bitstarz-eng/event-processing-challenge/internal/casino"
+)
+
+const (
+	defaultBatchSize = 100
+	defaultLinger    = 10 * time.Millisecond
 
-	kafka "github.com/segmentio/kafka-go"
+	// Writes that fail are tried publishAttempts times in all, waiting
+	// publishBackoff and then twice as long each time, before the events are
+	// failed.
+	publishAttempts = 3
+	publishBackoff  = 100 * time.Millisecond
 )
 
+// Delivery reports whether a published event was written to the sink.
+type Delivery struct {
+	Event   casino.Event
+	Headers Headers
+	Err     error
+}
+
 type Publisher struct {
-	ctx     context.Context
-	writer  *kafka.Writer
-	eventCh <-chan casino.Event
+	ctx         context.Context
+	sink        Sink
+	eventCh     <-chan Envelope
+	stage       string
+	key         KeyStrategy
+	batchSize   int
+	linger      time.Duration
+	onDelivery  func(Delivery)
+	codec       Codec
+	cloudEvents CloudEventsMode
+}
+
+type PublisherOption func(*Publisher)
+
+// WithStage names the stage in the provenance headers of published events.
+func WithStage(stage string) PublisherOption {
+	return func(p *Publisher) {
+		p.stage = stage
+	}
 }
 
-func NewPublisher(ctx context.Context, writer *kafka.Writer, eventCh <-chan casino.Event) *Publisher {
-	return &Publisher{
-		ctx:     ctx,
-		writer:  writer,
-		eventCh: eventCh,
+func WithKeyStrategy(key KeyStrategy) PublisherOption {
+	return func(p *Publisher) {
+		p.key = key
 	}
 }
 
+// WithBatching writes events in batches of up to batchSize, waiting at most
+// linger for a batch to fill up.
+func WithBatching(batchSize int, linger time.Duration) PublisherOption {
+	return func(p *Publisher) {
+		if batchSize < 1 {
+			batchSize = 1
+		}
+		p.batchSize = batchSize
+		p.linger = linger
+	}
+}
+
+// WithDeliveryReports calls onDelivery for every event once its write has
+// succeeded or failed.
+func WithDeliveryReports(onDelivery func(Delivery)) PublisherOption {
+	return func(p *Publisher) {
+		p.onDelivery = onDelivery
+	}
+}
+
+// WithCodec encodes events with codec instead of JSON.
+func WithCodec(codec Codec) PublisherOption {
+	return func(p *Publisher) {
+		p.codec = codec
+	}
+}
+
+// WithCloudEvents wraps events in CloudEvents of the given mode, using the
+// stage as their source.
+func WithCloudEvents(mode CloudEventsMode) PublisherOption {
+	return func(p *Publisher) {
+		p.cloudEvents = mode
+	}
+}
+
+func NewPublisher(ctx context.Context, sink Sink, eventCh <-chan Envelope, options ...PublisherOption) *Publisher {
+	p := &Publisher{
+		ctx:       ctx,
+		sink:      sink,
+		eventCh:   eventCh,
+		key:       KeyByPlayerID,
+		batchSize: defaultBatchSize,
+		linger:    defaultLinger,
+		codec:     JSONCodec,
+	}
+	for _, option := range options {
+		option(p)
+	}
+	return p
+}
+
+// Publish writes events until the channel is closed and the last batch has
+// been flushed. Closing the sink is left to its owner.
 func (p *Publisher) Publish() {
-	for event := range p.eventCh {
-		err := p.publishEvent(event)
+	batch := make([]Envelope, 0, p.batchSize)
+	var lingerTimer *time.Timer
+	var lingerCh <-chan time.Time
+
+	flush := func() {
+		if lingerTimer != nil {
+			lingerTimer.Stop()
+			lingerTimer, lingerCh = nil, nil
+		}
+		if len(batch) > 0 {
+			p.publishBatch(batch)
+			batch = batch[:0]
+		}
+	}
+
+	for {
+		select {
+		case envelope, ok := <-p.eventCh:
+			if !ok {
+				flush()
+				return
+			}
+
+			batch = append(batch, envelope)
+			if len(batch) >= p.batchSize {
+				flush()
+			} else if lingerTimer == nil {
+				lingerTimer = time.NewTimer(p.linger)
+				lingerCh = lingerTimer.C
+			}
+		case <-lingerCh:
+			lingerTimer, lingerCh = nil, nil
+			flush()
+		}
+	}
+}
+
+// publishBatch writes the batch in one call and acknowledges the events that
+// were delivered. Events whose write keeps failing are failed, handing them to
+// the consumer's retry or dead-letter handling.
+func (p *Publisher) publishBatch(batch []Envelope) {
+	envelopes := make([]Envelope, 0, len(batch))
+	msgs := make([]Message, 0, len(batch))
+	for _, envelope := range batch {
+		msg, err := p.message(envelope)
 		if err != nil {
-			log.Printf("error publishing event: %v", err)
+			envelope.Fail(Permanent(err))
+			p.report(envelope, err)
+			continue
 		}
+		envelopes = append(envelopes, envelope)
+		msgs = append(msgs, msg)
 	}
 
-	err := p.writer.Close()
-	if err != nil {
-		log.Printf("failed to close writer: %v", err)
+	backoff := publishBackoff
+	for attempt := 1; len(msgs) > 0; attempt++ {
+		writeErrs := p.write(msgs)
+
+		var failedEnvelopes []Envelope
+		var failedMsgs []Message
+		for i, envelope := range envelopes {
+			switch {
+			case writeErrs[i] == nil:
+				envelope.Ack()
+				p.report(envelope, nil)
+			case attempt >= publishAttempts || p.ctx.Err() != nil:
+				log.Printf("error publishing event %d: %v", envelope.Event.ID, writeErrs[i])
+				envelope.Fail(fmt.Errorf("publish event: %v", writeErrs[i]))
+				p.report(envelope, writeErrs[i])
+			default:
+				failedEnvelopes = append(failedEnvelopes, envelope)
+				failedMsgs = append(failedMsgs, msgs[i])
+			}
+		}
+		if len(failedMsgs) == 0 {
+			return
+		}
+
+		log.Printf("error publishing %d events, trying again in %v: %v", len(failedMsgs), backoff, writeErrs.first())
+		select {
+		case <-time.After(backoff):
+		case <-p.ctx.Done():
+		}
+		backoff *= 2
+		envelopes, msgs = failedEnvelopes, failedMsgs
 	}
 }
 
-func (p *Publisher) publishEvent(event casino.Event) error {
-	jsonData, err := json.Marshal(event)
-	if err != nil {
-		return fmt.Errorf("marshalling event: %v", err)
+// write writes msgs to the sink, returning an error per message.
+func (p *Publisher) write(msgs []Message) WriteErrors {
+	err := p.sink.WriteMessages(p.ctx, msgs...)
+
+	var writeErrs WriteErrors
+	if !errors.As(err, &writeErrs) || len(writeErrs) != len(msgs) {
+		writeErrs = make(WriteErrors, len(msgs))
+		for i := range writeErrs {
+			writeErrs[i] = err
+		}
 	}
 
-	msg := kafka.Message{
-		Key:   []byte("Key-" + strconv.Itoa(event.ID)),
-		Value: jsonData,
+	return writeErrs
+}
+
+func (p *Publisher) report(envelope Envelope, err error) {
+	if p.onDelivery != nil {
+		p.onDelivery(Delivery{Event: envelope.Event, Headers: envelope.Headers, Err: err})
 	}
+}
 
-	err = p.writer.WriteMessages(p.ctx, msg)
+func (p *Publisher) message(envelope Envelope) (Message, error) {
+	value, err := p.codec.Encode(envelope.Event)
 	if err != nil {
-		return fmt.Errorf("error writing message: %v", err)
+		return Message{}, fmt.Errorf("encoding event: %v", err)
+	}
+
+	// Failure handling and CloudEvents headers describe this stage's input,
+	// not its output.
+	headers := withoutCloudEvents(envelope.Headers.Without(retryHeaders...).Without(deadLetterHeaders...))
+	err = headers.stamp(p.stage, time.Now())
+	if err != nil {
+		return Message{}, err
+	}
+
+	if p.cloudEvents != CloudEventsOff {
+		value, err = wrapCloudEvent(p.cloudEvents, p.stage, envelope.Event, p.codec, value, &headers)
+		if err != nil {
+			return Message{}, err
+		}
+	} else {
+		headers.Set(HeaderContentType, p.codec.ContentType())
 	}
 
-	return nil
+	return Message{
+		Key:     p.key(envelope.Event),
+		Value:   value,
+		Headers: headers,
+	}, nil
 }
@@ -0,0 +1,75 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/Bitstarz-eng/event-processing-challenge/internal/casino"
+)
+
+// flakySink fails the first failures writes.
+type flakySink struct {
+	failures int
+	writes   int
+}
+
+func (s *flakySink) WriteMessages(ctx context.Context, msgs ...Message) error {
+	s.writes++
+	if s.writes <= s.failures {
+		return errors.New("broker unavailable")
+	}
+	return nil
+}
+
+func (s *flakySink) Close() error {
+	return nil
+}
+
+type recordingAcker struct {
+	mu     sync.Mutex
+	acked  int
+	failed []error
+}
+
+func (a *recordingAcker) ack(msg Message) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.acked++
+}
+
+func (a *recordingAcker) fail(msg Message, err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.failed = append(a.failed, err)
+}
+
+func TestPublisherWriteFailures(t *testing.T) {
+	tests := []struct {
+		name       string
+		failures   int
+		wantAcked  int
+		wantFailed int
+	}{
+		{name: "delivered", failures: 0, wantAcked: 1},
+		{name: "delivered after retrying", failures: publishAttempts - 1, wantAcked: 1},
+		{name: "failed", failures: publishAttempts, wantFailed: 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sink := &flakySink{failures: test.failures}
+			acker := &recordingAcker{}
+			eventCh := make(chan Envelope, 1)
+			eventCh <- Envelope{Event: casino.Event{ID: 1, Type: "bet"}, acker: acker}
+			close(eventCh)
+
+			NewPublisher(context.Background(), sink, eventCh).Publish()
+
+			if acker.acked != test.wantAcked || len(acker.failed) != test.wantFailed {
+				t.Errorf("got %d acked and %d failed, want %d and %d", acker.acked, len(acker.failed), test.wantAcked, test.wantFailed)
+			}
+		})
+	}
+}
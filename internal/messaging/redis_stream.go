@@ -0,0 +1,342 @@
+package messaging
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+const (
+	streamFieldKey     = "key"
+	streamFieldValue   = "value"
+	streamFieldHeaders = "headers"
+	streamFieldTime    = "time"
+
+	streamReadCount = 64
+	streamReadBlock = time.Second
+)
+
+// RedisStreamSource reads a Redis stream through a consumer group. Every
+// entry has to be acknowledged with XACK, so the source remembers the IDs it
+// handed out and acknowledges all of them up to a committed message. Entries
+// left pending by consumers that crashed are claimed once they have been idle
+// for claimIdle, which relies on XAUTOCLAIM and so needs Redis 6.2 or newer.
+// Reads and commits lock separately, so that entries are acknowledged while a
+// read blocks waiting for new ones.
+type RedisStreamSource struct {
+	redisClient *redis.Client
+	stream      string
+	group       string
+	consumer    string
+	claimIdle   time.Duration
+
+	fetchMu    sync.Mutex
+	ready      bool
+	ownPending string
+	nextClaim  time.Time
+	claimStart string
+	buffered   []Message
+	nextOffset int64
+
+	mu      sync.Mutex
+	pending []streamEntry
+}
+
+type streamEntry struct {
+	offset int64
+	id     string
+}
+
+func NewRedisStreamSource(redisClient *redis.Client, stream, group, consumer string, claimIdle time.Duration) *RedisStreamSource {
+	return &RedisStreamSource{
+		redisClient: redisClient,
+		stream:      stream,
+		group:       group,
+		consumer:    consumer,
+		claimIdle:   claimIdle,
+		ownPending:  "0",
+		claimStart:  "0-0",
+	}
+}
+
+func (s *RedisStreamSource) FetchMessage(ctx context.Context) (Message, error) {
+	s.fetchMu.Lock()
+	defer s.fetchMu.Unlock()
+
+	err := s.createGroup(ctx)
+	if err != nil {
+		return Message{}, err
+	}
+
+	for len(s.buffered) == 0 {
+		if ctx.Err() != nil {
+			return Message{}, ctx.Err()
+		}
+
+		entries, err := s.read(ctx)
+		if err != nil {
+			return Message{}, err
+		}
+		for _, entry := range entries {
+			msg, err := s.message(entry)
+			if err != nil {
+				return Message{}, err
+			}
+			s.buffered = append(s.buffered, msg)
+		}
+	}
+
+	msg := s.buffered[0]
+	s.buffered = s.buffered[1:]
+	return msg, nil
+}
+
+// CommitMessages acknowledges every entry handed out up to and including the
+// given messages.
+func (s *RedisStreamSource) CommitMessages(ctx context.Context, msgs ...Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var upTo int64 = -1
+	for _, msg := range msgs {
+		if msg.Offset > upTo {
+			upTo = msg.Offset
+		}
+	}
+
+	var ids []string
+	n := 0
+	for n < len(s.pending) && s.pending[n].offset <= upTo {
+		ids = append(ids, s.pending[n].id)
+		n++
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	err := s.redisClient.XAck(ctx, s.stream, s.group, ids...).Err()
+	if err != nil {
+		return fmt.Errorf("xack: %v", err)
+	}
+	s.pending = s.pending[n:]
+
+	return nil
+}
+
+func (s *RedisStreamSource) Close() error {
+	return nil
+}
+
+func (s *RedisStreamSource) createGroup(ctx context.Context) error {
+	if s.ready {
+		return nil
+	}
+
+	err := s.redisClient.XGroupCreateMkStream(ctx, s.stream, s.group, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return fmt.Errorf("create consumer group: %v", err)
+	}
+	s.ready = true
+
+	return nil
+}
+
+// read returns, in order of preference, entries this consumer was handed
+// before a restart, entries abandoned by other consumers and new entries.
+func (s *RedisStreamSource) read(ctx context.Context) ([]redis.XMessage, error) {
+	if s.ownPending != "" {
+		entries, err := s.readGroup(ctx, s.ownPending, -1)
+		if err != nil {
+			return nil, err
+		}
+		if len(entries) > 0 {
+			s.ownPending = entries[len(entries)-1].ID
+			return entries, nil
+		}
+		s.ownPending = ""
+	}
+
+	if s.claimIdle > 0 && time.Now().After(s.nextClaim) {
+		entries, err := s.claim(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if len(entries) > 0 {
+			return entries, nil
+		}
+	}
+
+	return s.readGroup(ctx, ">", streamReadBlock)
+}
+
+// claim takes over entries that have been idle for claimIdle, paging through
+// the pending entries of the group until it finds some or has seen all of
+// them. Entries this consumer is still buffering or processing can be idle
+// that long too, for example while they wait for a delayed retry, so they are
+// skipped instead of being handed out a second time.
+func (s *RedisStreamSource) claim(ctx context.Context) ([]redis.XMessage, error) {
+	s.mu.Lock()
+	handedOut := make(map[string]bool, len(s.pending))
+	for _, entry := range s.pending {
+		handedOut[entry.id] = true
+	}
+	s.mu.Unlock()
+
+	for {
+		entries, next, err := s.redisClient.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+			Stream:   s.stream,
+			Group:    s.group,
+			Consumer: s.consumer,
+			MinIdle:  s.claimIdle,
+			Start:    s.claimStart,
+			Count:    streamReadCount,
+		}).Result()
+		if err != nil {
+			return nil, fmt.Errorf("xautoclaim: %v", err)
+		}
+
+		claimed := entries[:0]
+		for _, entry := range entries {
+			if !handedOut[entry.ID] {
+				claimed = append(claimed, entry)
+			}
+		}
+
+		if next == "" || next == "0-0" {
+			s.claimStart = "0-0"
+			s.nextClaim = time.Now().Add(s.claimIdle / 2)
+			return claimed, nil
+		}
+		s.claimStart = next
+		if len(claimed) > 0 {
+			return claimed, nil
+		}
+	}
+}
+
+func (s *RedisStreamSource) readGroup(ctx context.Context, id string, block time.Duration) ([]redis.XMessage, error) {
+	streams, err := s.redisClient.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    s.group,
+		Consumer: s.consumer,
+		Streams:  []string{s.stream, id},
+		Count:    streamReadCount,
+		Block:    block,
+	}).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("xreadgroup: %v", err)
+	}
+
+	var entries []redis.XMessage
+	for _, stream := range streams {
+		entries = append(entries, stream.Messages...)
+	}
+	return entries, nil
+}
+
+// message decodes a stream entry and assigns it the next offset of this
+// source, so that it can be tracked like any other partition offset.
+func (s *RedisStreamSource) message(entry redis.XMessage) (Message, error) {
+	msg := Message{
+		Topic:  s.stream,
+		Offset: s.nextOffset,
+	}
+
+	if key, ok := entry.Values[streamFieldKey].(string); ok {
+		msg.Key = []byte(key)
+	}
+	if value, ok := entry.Values[streamFieldValue].(string); ok {
+		msg.Value = []byte(value)
+	}
+	if headers, ok := entry.Values[streamFieldHeaders].(string); ok && headers != "" {
+		err := json.Unmarshal([]byte(headers), &msg.Headers)
+		if err != nil {
+			return Message{}, fmt.Errorf("decode headers of entry %s: %v", entry.ID, err)
+		}
+	}
+	if ts, ok := entry.Values[streamFieldTime].(string); ok {
+		unixNano, err := strconv.ParseInt(ts, 10, 64)
+		if err == nil {
+			msg.Time = time.Unix(0, unixNano)
+		}
+	}
+
+	s.mu.Lock()
+	s.pending = append(s.pending, streamEntry{offset: s.nextOffset, id: entry.ID})
+	s.mu.Unlock()
+	s.nextOffset++
+	return msg, nil
+}
+
+// RedisStreamSink appends messages to a Redis stream with XADD, trimming it
+// to roughly maxLen entries when maxLen is positive.
+type RedisStreamSink struct {
+	redisClient *redis.Client
+	stream      string
+	maxLen      int64
+}
+
+func NewRedisStreamSink(redisClient *redis.Client, stream string, maxLen int64) *RedisStreamSink {
+	return &RedisStreamSink{
+		redisClient: redisClient,
+		stream:      stream,
+		maxLen:      maxLen,
+	}
+}
+
+func (s *RedisStreamSink) WriteMessages(ctx context.Context, msgs ...Message) error {
+	pipe := s.redisClient.Pipeline()
+	cmds := make([]*redis.StringCmd, len(msgs))
+	for i, msg := range msgs {
+		stream := s.stream
+		if stream == "" {
+			stream = msg.Topic
+		}
+
+		headers, err := json.Marshal(msg.Headers)
+		if err != nil {
+			return fmt.Errorf("encode headers: %v", err)
+		}
+
+		ts := msg.Time
+		if ts.IsZero() {
+			ts = time.Now()
+		}
+
+		cmds[i] = pipe.XAdd(ctx, &redis.XAddArgs{
+			Stream: stream,
+			MaxLen: s.maxLen,
+			Approx: s.maxLen > 0,
+			Values: map[string]interface{}{
+				streamFieldKey:     msg.Key,
+				streamFieldValue:   msg.Value,
+				streamFieldHeaders: headers,
+				streamFieldTime:    ts.UnixNano(),
+			},
+		})
+	}
+
+	_, err := pipe.Exec(ctx)
+	if err == nil {
+		return nil
+	}
+
+	writeErrs := make(WriteErrors, len(msgs))
+	for i, cmd := range cmds {
+		writeErrs[i] = cmd.Err()
+	}
+	return writeErrs
+}
+
+func (s *RedisStreamSink) Close() error {
+	return nil
+}
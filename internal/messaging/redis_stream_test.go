@@ -0,0 +1,200 @@
+package messaging
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+func TestRedisStreamCommitWhileFetchBlocks(t *testing.T) {
+	server := newStreamServer(t)
+	defer server.close()
+
+	client := redis.NewClient(&redis.Options{Addr: server.addr()})
+	defer client.Close()
+
+	source := NewRedisStreamSource(client, "events", "group", "consumer", 0)
+	msg, err := source.FetchMessage(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fetchCtx, cancel := context.WithCancel(context.Background())
+	fetched := make(chan struct{})
+	go func() {
+		defer close(fetched)
+		source.FetchMessage(fetchCtx)
+	}()
+	defer func() {
+		cancel()
+		<-fetched
+	}()
+
+	// Commit while the stream is empty and the second fetch blocks reading it.
+	<-server.blocked
+	committed := make(chan error, 1)
+	go func() {
+		committed <- source.CommitMessages(context.Background(), msg)
+	}()
+	select {
+	case err := <-committed:
+		if err != nil {
+			t.Fatalf("commit during blocking read: %v", err)
+		}
+	case <-time.After(streamReadBlock / 2):
+		t.Fatal("commit waited for the blocking read")
+	}
+	if acked := server.ackedIDs(); len(acked) != 1 || acked[0] != "1-0" {
+		t.Errorf("acknowledged %v, want [1-0]", acked)
+	}
+}
+
+// streamServer speaks enough RESP to hand out one stream entry to a consumer
+// group and then block every read for new entries until it is closed.
+type streamServer struct {
+	listener net.Listener
+	blocked  chan struct{}
+	done     chan struct{}
+
+	mu        sync.Mutex
+	delivered bool
+	acked     []string
+}
+
+func newStreamServer(t *testing.T) *streamServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &streamServer{
+		listener: listener,
+		blocked:  make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *streamServer) addr() string {
+	return s.listener.Addr().String()
+}
+
+func (s *streamServer) close() {
+	close(s.done)
+	s.listener.Close()
+}
+
+func (s *streamServer) ackedIDs() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string(nil), s.acked...)
+}
+
+func (s *streamServer) serve(conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+
+		switch strings.ToUpper(args[0]) {
+		case "XREADGROUP":
+			s.readGroup(conn, args)
+		case "XACK":
+			s.mu.Lock()
+			s.acked = append(s.acked, args[3:]...)
+			s.mu.Unlock()
+			fmt.Fprintf(conn, ":%d\r\n", len(args)-3)
+		default:
+			fmt.Fprint(conn, "+OK\r\n")
+		}
+	}
+}
+
+func (s *streamServer) readGroup(conn net.Conn, args []string) {
+	if args[len(args)-1] != ">" {
+		fmt.Fprint(conn, "*-1\r\n")
+		return
+	}
+
+	s.mu.Lock()
+	delivered := s.delivered
+	s.delivered = true
+	s.mu.Unlock()
+
+	if !delivered {
+		value := `{"id":1}`
+		fmt.Fprintf(conn, "*1\r\n*2\r\n$6\r\nevents\r\n*1\r\n*2\r\n$3\r\n1-0\r\n*2\r\n$5\r\nvalue\r\n$%d\r\n%s\r\n", len(value), value)
+		return
+	}
+
+	var block time.Duration
+	for i, arg := range args {
+		if strings.ToUpper(arg) == "BLOCK" {
+			ms, _ := strconv.Atoi(args[i+1])
+			block = time.Duration(ms) * time.Millisecond
+		}
+	}
+
+	select {
+	case s.blocked <- struct{}{}:
+	default:
+	}
+	select {
+	case <-time.After(block):
+	case <-s.done:
+	}
+	fmt.Fprint(conn, "*-1\r\n")
+}
+
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(line)[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	args := make([]string, n)
+	for i := range args {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(strings.TrimSpace(line)[1:])
+		if err != nil {
+			return nil, err
+		}
+		arg := make([]byte, size+2)
+		_, err = io.ReadFull(r, arg)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = string(arg[:size])
+	}
+	return args, nil
+}
@@ -0,0 +1,202 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+const (
+	HeaderRetryAttempts  = "retry.attempts"
+	HeaderRetryNotBefore = "retry.not-before"
+	HeaderRetryError     = "retry.error"
+	HeaderRetryTopic     = "retry.original.topic"
+	HeaderRetryPartition = "retry.original.partition"
+	HeaderRetryOffset    = "retry.original.offset"
+)
+
+var retryHeaders = []string{
+	HeaderRetryAttempts,
+	HeaderRetryNotBefore,
+	HeaderRetryError,
+	HeaderRetryTopic,
+	HeaderRetryPartition,
+	HeaderRetryOffset,
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent marks a failure that retrying cannot fix, so that the message goes
+// straight to the dead-letter topic.
+func Permanent(err error) error {
+	return permanentError{err: err}
+}
+
+func IsPermanent(err error) bool {
+	var permanent permanentError
+	return errors.As(err, &permanent)
+}
+
+// RetryTier is a retry topic whose messages are held back for Delay before
+// they are consumed again.
+type RetryTier struct {
+	Delay time.Duration
+	Sink  Sink
+}
+
+// Retrier re-publishes failed messages to delayed retry topics, backing off
+// to a later tier with every attempt until MaxAttempts is reached.
+type Retrier struct {
+	tiers       []RetryTier
+	maxAttempts int
+}
+
+func NewRetrier(tiers []RetryTier, maxAttempts int) *Retrier {
+	return &Retrier{
+		tiers:       tiers,
+		maxAttempts: maxAttempts,
+	}
+}
+
+// ShouldRetry reports whether a message that has failed attempts times may be
+// tried again.
+func (r *Retrier) ShouldRetry(err error, attempts int) bool {
+	return len(r.tiers) > 0 && !IsPermanent(err) && attempts < r.maxAttempts
+}
+
+func (r *Retrier) Send(ctx context.Context, msg Message, cause error, attempts int) error {
+	tierIdx := attempts - 1
+	if tierIdx >= len(r.tiers) {
+		tierIdx = len(r.tiers) - 1
+	}
+	tier := r.tiers[tierIdx]
+
+	origin := originOf(msg)
+	headers := msg.Headers.Without(retryHeaders...)
+	headers = append(headers,
+		Header{Key: HeaderRetryAttempts, Value: []byte(strconv.Itoa(attempts))},
+		Header{Key: HeaderRetryNotBefore, Value: []byte(time.Now().Add(tier.Delay).Format(time.RFC3339Nano))},
+		Header{Key: HeaderRetryError, Value: []byte(cause.Error())},
+		Header{Key: HeaderRetryTopic, Value: []byte(origin.Topic)},
+		Header{Key: HeaderRetryPartition, Value: []byte(strconv.Itoa(origin.Partition))},
+		Header{Key: HeaderRetryOffset, Value: []byte(strconv.FormatInt(origin.Offset, 10))},
+	)
+
+	err := tier.Sink.WriteMessages(ctx, Message{
+		Key:     msg.Key,
+		Value:   msg.Value,
+		Headers: headers,
+	})
+	if err != nil {
+		return fmt.Errorf("write retry after %s: %v", tier.Delay, err)
+	}
+
+	return nil
+}
+
+func (r *Retrier) Close() error {
+	var firstErr error
+	for _, tier := range r.tiers {
+		err := tier.Sink.Close()
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
+
+// attemptsOf returns how many times the message has already failed.
+func attemptsOf(msg Message) int {
+	value, ok := msg.Headers.Get(HeaderRetryAttempts)
+	if !ok {
+		return 0
+	}
+
+	attempts, err := strconv.Atoi(value)
+	if err != nil {
+		return 0
+	}
+	return attempts
+}
+
+// originOf returns the topic position the message was first consumed from,
+// looking through any retry topics it has passed.
+func originOf(msg Message) Message {
+	topic, ok := msg.Headers.Get(HeaderRetryTopic)
+	if !ok {
+		return msg
+	}
+
+	partition, _ := msg.Headers.Get(HeaderRetryPartition)
+	offset, _ := msg.Headers.Get(HeaderRetryOffset)
+
+	origin := Message{Topic: topic}
+	origin.Partition, _ = strconv.Atoi(partition)
+	origin.Offset, _ = strconv.ParseInt(offset, 10, 64)
+	return origin
+}
+
+// DelayedSource holds every message back until the not-before time stamped on
+// it by a Retrier. Messages on a retry topic share one delay and are therefore
+// ordered by that time, so waiting on the head of the topic is enough.
+type DelayedSource struct {
+	source Source
+}
+
+func NewDelayedSource(source Source) *DelayedSource {
+	return &DelayedSource{
+		source: source,
+	}
+}
+
+func (s *DelayedSource) FetchMessage(ctx context.Context) (Message, error) {
+	msg, err := s.source.FetchMessage(ctx)
+	if err != nil {
+		return Message{}, err
+	}
+
+	value, ok := msg.Headers.Get(HeaderRetryNotBefore)
+	if !ok {
+		return msg, nil
+	}
+	notBefore, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return msg, nil
+	}
+
+	wait := time.Until(notBefore)
+	if wait <= 0 {
+		return msg, nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return Message{}, ctx.Err()
+	case <-timer.C:
+		return msg, nil
+	}
+}
+
+func (s *DelayedSource) CommitMessages(ctx context.Context, msgs ...Message) error {
+	return s.source.CommitMessages(ctx, msgs...)
+}
+
+func (s *DelayedSource) Close() error {
+	return s.source.Close()
+}
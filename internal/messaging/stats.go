@@ -0,0 +1,211 @@
+package messaging
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// rateWindow is how far back message rates are averaged.
+const rateWindow = 60
+
+// endOffsetsTimeout bounds looking up end offsets when stats are requested.
+const endOffsetsTimeout = 2 * time.Second
+
+// endOffsetLister is implemented by sources that can look up the end offsets
+// of partitions, so that lag keeps growing while a consumer fetches nothing.
+// Other sources only know the high watermarks of the messages they fetched.
+type endOffsetLister interface {
+	endOffsets(ctx context.Context, partitions map[string][]int) (map[topicPartition]int64, error)
+}
+
+// PartitionStats describes how far a consumer is behind on one partition. Lag
+// is left out when the transport does not report high watermarks.
+type PartitionStats struct {
+	Topic                   string  `json:"topic"`
+	Partition               int     `json:"partition"`
+	HighWaterMark           int64   `json:"high_water_mark"`
+	Committed               int64   `json:"committed"`
+	Lag                     *int64  `json:"lag,omitempty"`
+	MessagesPerSecond       float64 `json:"messages_per_second"`
+	SecondsSinceLastMessage float64 `json:"seconds_since_last_message"`
+}
+
+// ConsumerStats describes the progress of a consumer over all its partitions.
+type ConsumerStats struct {
+	Partitions              []PartitionStats `json:"partitions"`
+	Lag                     int64            `json:"lag"`
+	MessagesPerSecond       float64          `json:"messages_per_second"`
+	SecondsSinceLastMessage *float64         `json:"seconds_since_last_message,omitempty"`
+}
+
+type partitionProgress struct {
+	highWaterMark int64
+	committed     int64
+	lastMessage   time.Time
+	rate          *rateMeter
+}
+
+// consumerProgress records fetched and committed offsets of a consumer.
+type consumerProgress struct {
+	mu          sync.Mutex
+	partitions  map[topicPartition]*partitionProgress
+	rate        *rateMeter
+	lastMessage time.Time
+}
+
+func newConsumerProgress() *consumerProgress {
+	return &consumerProgress{
+		partitions: make(map[topicPartition]*partitionProgress),
+		rate:       newRateMeter(time.Now()),
+	}
+}
+
+func (p *consumerProgress) fetched(msg Message, now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	tp := topicPartition{topic: msg.Topic, partition: msg.Partition}
+	partition, ok := p.partitions[tp]
+	if !ok {
+		// Until the first commit, the group is assumed to be at the first
+		// message this consumer was handed.
+		partition = &partitionProgress{
+			committed: msg.Offset,
+			rate:      newRateMeter(now),
+		}
+		p.partitions[tp] = partition
+	}
+
+	if msg.HighWaterMark > partition.highWaterMark {
+		partition.highWaterMark = msg.HighWaterMark
+	}
+	partition.lastMessage = now
+	partition.rate.add(now)
+
+	p.lastMessage = now
+	p.rate.add(now)
+}
+
+// partitionsByTopic returns the partitions messages were fetched from.
+func (p *consumerProgress) partitionsByTopic() map[string][]int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	partitions := make(map[string][]int)
+	for tp := range p.partitions {
+		partitions[tp.topic] = append(partitions[tp.topic], tp.partition)
+	}
+	return partitions
+}
+
+func (p *consumerProgress) endOffsets(offsets map[topicPartition]int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for tp, offset := range offsets {
+		partition, ok := p.partitions[tp]
+		if ok && offset > partition.highWaterMark {
+			partition.highWaterMark = offset
+		}
+	}
+}
+
+func (p *consumerProgress) committed(msg Message) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	partition, ok := p.partitions[topicPartition{topic: msg.Topic, partition: msg.Partition}]
+	if ok && msg.Offset+1 > partition.committed {
+		partition.committed = msg.Offset + 1
+	}
+}
+
+func (p *consumerProgress) stats(now time.Time) ConsumerStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	stats := ConsumerStats{
+		Partitions:        make([]PartitionStats, 0, len(p.partitions)),
+		MessagesPerSecond: p.rate.rate(now),
+	}
+	if !p.lastMessage.IsZero() {
+		since := now.Sub(p.lastMessage).Seconds()
+		stats.SecondsSinceLastMessage = &since
+	}
+
+	for tp, partition := range p.partitions {
+		partitionStats := PartitionStats{
+			Topic:                   tp.topic,
+			Partition:               tp.partition,
+			HighWaterMark:           partition.highWaterMark,
+			Committed:               partition.committed,
+			MessagesPerSecond:       partition.rate.rate(now),
+			SecondsSinceLastMessage: now.Sub(partition.lastMessage).Seconds(),
+		}
+		if partition.highWaterMark > 0 {
+			lag := partition.highWaterMark - partition.committed
+			if lag < 0 {
+				lag = 0
+			}
+			partitionStats.Lag = &lag
+			stats.Lag += lag
+		}
+		stats.Partitions = append(stats.Partitions, partitionStats)
+	}
+
+	sort.Slice(stats.Partitions, func(i, j int) bool {
+		a, b := stats.Partitions[i], stats.Partitions[j]
+		if a.Topic != b.Topic {
+			return a.Topic < b.Topic
+		}
+		return a.Partition < b.Partition
+	})
+
+	return stats
+}
+
+// rateMeter counts events in one-second buckets to average their rate over
+// the last rateWindow seconds.
+type rateMeter struct {
+	start   time.Time
+	seconds [rateWindow]int64
+	counts  [rateWindow]int64
+}
+
+func newRateMeter(start time.Time) *rateMeter {
+	return &rateMeter{
+		start: start,
+	}
+}
+
+func (m *rateMeter) add(now time.Time) {
+	second := now.Unix()
+	idx := second % rateWindow
+	if m.seconds[idx] != second {
+		m.seconds[idx] = second
+		m.counts[idx] = 0
+	}
+	m.counts[idx]++
+}
+
+func (m *rateMeter) rate(now time.Time) float64 {
+	second := now.Unix()
+
+	var total int64
+	for i := range m.seconds {
+		if second-m.seconds[i] < rateWindow {
+			total += m.counts[i]
+		}
+	}
+
+	window := now.Sub(m.start).Seconds()
+	if window > rateWindow {
+		window = rateWindow
+	}
+	if window < 1 {
+		window = 1
+	}
+	return float64(total) / window
+}
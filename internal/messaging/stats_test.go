@@ -0,0 +1,43 @@
+package messaging
+
+import (
+	"context"
+	"testing"
+)
+
+func TestConsumerStatsLagFromEndOffsets(t *testing.T) {
+	broker := NewBroker()
+	sink := broker.Sink("events")
+	write := func(n int) {
+		for i := 0; i < n; i++ {
+			err := sink.WriteMessages(context.Background(), Message{Value: []byte(`{"id":1}`)})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	eventCh := make(chan Envelope, 1)
+	consumer := NewConsumer(context.Background(), broker.Source("events", "group"), eventCh)
+	defer consumer.Close()
+
+	write(1)
+	err := consumer.consumeMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Messages written since are only known from the end offsets.
+	write(4)
+	stats := consumer.Stats()
+	if stats.Lag != 5 {
+		t.Errorf("got lag %d, want 5", stats.Lag)
+	}
+
+	(<-eventCh).Ack()
+	consumer.Close()
+	stats = consumer.Stats()
+	if stats.Lag != 4 {
+		t.Errorf("got lag %d after committing, want 4", stats.Lag)
+	}
+}
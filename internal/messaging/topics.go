@@ -0,0 +1,304 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Bitstarz-eng/event-processing-challenge/internal/config"
+
+	kafka "github.com/segmentio/kafka-go"
+)
+
+// Topics just created have no partition leaders for a moment. Their metadata
+// is polled up to topicReadyAttempts times, waiting topicReadyBackoff and then
+// twice as long each time, before they are validated.
+const (
+	topicReadyAttempts = 8
+	topicReadyBackoff  = 100 * time.Millisecond
+)
+
+// TopicSpec declares a topic a service reads or writes. A zero Retention
+// leaves retention to the broker default.
+type TopicSpec struct {
+	Name              string
+	Partitions        int
+	ReplicationFactor int
+	Retention         time.Duration
+}
+
+// TopicKind tells which of the configured layouts a topic has.
+type TopicKind int
+
+const (
+	MainTopic TopicKind = iota
+	RetryTopic
+	DeadLetterTopic
+)
+
+// TopicSpecs declares the named topics of a kind, laid out as configured for
+// the kind unless a topic is overridden.
+func TopicSpecs(topicsCfg config.Topics, kind TopicKind, names ...string) []TopicSpec {
+	settings := topicsCfg.Main
+	switch kind {
+	case RetryTopic:
+		settings = topicsCfg.Retry
+	case DeadLetterTopic:
+		settings = topicsCfg.DeadLetter
+	}
+
+	specs := make([]TopicSpec, len(names))
+	for i, name := range names {
+		specs[i] = TopicSpec{
+			Name:              name,
+			Partitions:        settings.Partitions,
+			ReplicationFactor: topicsCfg.ReplicationFactor,
+			Retention:         settings.Retention,
+		}
+
+		override := topicsCfg.Overrides[name]
+		if override.Partitions > 0 {
+			specs[i].Partitions = override.Partitions
+		}
+		if override.Retention > 0 {
+			specs[i].Retention = override.Retention
+		}
+	}
+
+	return specs
+}
+
+// TopicMismatchError lists how existing topics differ from their specs.
+type TopicMismatchError []string
+
+func (e TopicMismatchError) Error() string {
+	return "topics do not match their declaration: " + strings.Join(e, "; ")
+}
+
+// ensureKafkaTopics creates the missing topics when create is set and checks
+// that all topics have the declared partitions, replicas and retention.
+func ensureKafkaTopics(ctx context.Context, client *kafka.Client, topics []TopicSpec, create bool) error {
+	missing, err := missingKafkaTopics(ctx, client, topics)
+	if err != nil {
+		return err
+	}
+
+	if len(missing) > 0 {
+		if !create {
+			names := make([]string, len(missing))
+			for i, spec := range missing {
+				names[i] = spec.Name
+			}
+			return fmt.Errorf("missing topics: %s", strings.Join(names, ", "))
+		}
+
+		err = createKafkaTopics(ctx, client, missing)
+		if err != nil {
+			return err
+		}
+
+		err = waitForKafkaTopics(ctx, client, missing)
+		if err != nil {
+			return err
+		}
+	}
+
+	return validateKafkaTopics(ctx, client, topics)
+}
+
+func missingKafkaTopics(ctx context.Context, client *kafka.Client, topics []TopicSpec) ([]TopicSpec, error) {
+	metadata, err := kafkaMetadata(ctx, client, topics)
+	if err != nil {
+		return nil, err
+	}
+
+	var missing []TopicSpec
+	for _, spec := range topics {
+		topic, ok := metadata[spec.Name]
+		if !ok || errors.Is(topic.Error, kafka.UnknownTopicOrPartition) {
+			missing = append(missing, spec)
+			continue
+		}
+		if topic.Error != nil {
+			return nil, fmt.Errorf("metadata of topic %s: %v", spec.Name, topic.Error)
+		}
+	}
+
+	return missing, nil
+}
+
+func createKafkaTopics(ctx context.Context, client *kafka.Client, topics []TopicSpec) error {
+	configs := make([]kafka.TopicConfig, len(topics))
+	for i, spec := range topics {
+		configs[i] = kafka.TopicConfig{
+			Topic:             spec.Name,
+			NumPartitions:     spec.Partitions,
+			ReplicationFactor: spec.ReplicationFactor,
+		}
+		if spec.Retention > 0 {
+			configs[i].ConfigEntries = []kafka.ConfigEntry{{
+				ConfigName:  "retention.ms",
+				ConfigValue: strconv.FormatInt(spec.Retention.Milliseconds(), 10),
+			}}
+		}
+	}
+
+	resp, err := client.CreateTopics(ctx, &kafka.CreateTopicsRequest{Topics: configs})
+	if err != nil {
+		return fmt.Errorf("create topics: %v", err)
+	}
+
+	for name, err := range resp.Errors {
+		// Another service may have created the topic in the meantime.
+		if err != nil && !errors.Is(err, kafka.TopicAlreadyExists) {
+			return fmt.Errorf("create topic %s: %v", name, err)
+		}
+	}
+
+	return nil
+}
+
+// waitForKafkaTopics waits until every partition of the topics has a leader.
+func waitForKafkaTopics(ctx context.Context, client *kafka.Client, topics []TopicSpec) error {
+	backoff := topicReadyBackoff
+	for attempt := 1; ; attempt++ {
+		metadata, err := kafkaMetadata(ctx, client, topics)
+		if err != nil {
+			return err
+		}
+
+		var notReady []string
+		for _, spec := range topics {
+			if !kafkaTopicReady(metadata[spec.Name]) {
+				notReady = append(notReady, spec.Name)
+			}
+		}
+		if len(notReady) == 0 {
+			return nil
+		}
+		if attempt >= topicReadyAttempts {
+			return fmt.Errorf("topics not ready after creating them: %s", strings.Join(notReady, ", "))
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		backoff *= 2
+	}
+}
+
+func kafkaTopicReady(topic kafka.Topic) bool {
+	if topic.Error != nil || len(topic.Partitions) == 0 {
+		return false
+	}
+	for _, partition := range topic.Partitions {
+		// Partitions without a leader, reported as LeaderNotAvailable, have no
+		// known broker as their leader.
+		if partition.Error != nil || partition.Leader.Host == "" {
+			return false
+		}
+	}
+
+	return true
+}
+
+func validateKafkaTopics(ctx context.Context, client *kafka.Client, topics []TopicSpec) error {
+	metadata, err := kafkaMetadata(ctx, client, topics)
+	if err != nil {
+		return err
+	}
+
+	retention, err := kafkaRetention(ctx, client, topics)
+	if err != nil {
+		return err
+	}
+
+	var mismatches TopicMismatchError
+	for _, spec := range topics {
+		topic, ok := metadata[spec.Name]
+		if !ok || topic.Error != nil {
+			mismatches = append(mismatches, fmt.Sprintf("%s is missing", spec.Name))
+			continue
+		}
+
+		if len(topic.Partitions) != spec.Partitions {
+			mismatches = append(mismatches, fmt.Sprintf("%s has %d partitions, want %d", spec.Name, len(topic.Partitions), spec.Partitions))
+		}
+		for _, partition := range topic.Partitions {
+			if len(partition.Replicas) != spec.ReplicationFactor {
+				mismatches = append(mismatches, fmt.Sprintf("%s has replication factor %d, want %d", spec.Name, len(partition.Replicas), spec.ReplicationFactor))
+				break
+			}
+		}
+
+		if spec.Retention > 0 {
+			want := strconv.FormatInt(spec.Retention.Milliseconds(), 10)
+			if got := retention[spec.Name]; got != want {
+				mismatches = append(mismatches, fmt.Sprintf("%s has retention.ms %s, want %s", spec.Name, got, want))
+			}
+		}
+	}
+
+	if len(mismatches) > 0 {
+		return mismatches
+	}
+	return nil
+}
+
+func kafkaMetadata(ctx context.Context, client *kafka.Client, topics []TopicSpec) (map[string]kafka.Topic, error) {
+	names := make([]string, len(topics))
+	for i, spec := range topics {
+		names[i] = spec.Name
+	}
+
+	resp, err := client.Metadata(ctx, &kafka.MetadataRequest{Topics: names})
+	if err != nil {
+		return nil, fmt.Errorf("metadata: %v", err)
+	}
+
+	metadata := make(map[string]kafka.Topic, len(resp.Topics))
+	for _, topic := range resp.Topics {
+		metadata[topic.Name] = topic
+	}
+	return metadata, nil
+}
+
+// kafkaRetention returns retention.ms of the topics that declare a retention.
+func kafkaRetention(ctx context.Context, client *kafka.Client, topics []TopicSpec) (map[string]string, error) {
+	var resources []kafka.DescribeConfigRequestResource
+	for _, spec := range topics {
+		if spec.Retention > 0 {
+			resources = append(resources, kafka.DescribeConfigRequestResource{
+				ResourceType: kafka.ResourceTypeTopic,
+				ResourceName: spec.Name,
+				ConfigNames:  []string{"retention.ms"},
+			})
+		}
+	}
+	if len(resources) == 0 {
+		return nil, nil
+	}
+
+	resp, err := client.DescribeConfigs(ctx, &kafka.DescribeConfigsRequest{Resources: resources})
+	if err != nil {
+		return nil, fmt.Errorf("describe topic configs: %v", err)
+	}
+
+	retention := make(map[string]string, len(resp.Resources))
+	for _, resource := range resp.Resources {
+		if resource.Error != nil {
+			return nil, fmt.Errorf("describe config of topic %s: %v", resource.ResourceName, resource.Error)
+		}
+		for _, entry := range resource.ConfigEntries {
+			if entry.ConfigName == "retention.ms" {
+				retention[resource.ResourceName] = entry.ConfigValue
+			}
+		}
+	}
+	return retention, nil
+}
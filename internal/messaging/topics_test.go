@@ -0,0 +1,79 @@
+package messaging
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Bitstarz-eng/event-processing-challenge/internal/config"
+
+	kafka "github.com/segmentio/kafka-go"
+)
+
+func TestTopicSpecs(t *testing.T) {
+	topicsCfg := config.Topics{
+		Main:              config.TopicSettings{Partitions: 3},
+		Retry:             config.TopicSettings{Partitions: 1, Retention: 24 * time.Hour},
+		DeadLetter:        config.TopicSettings{Partitions: 1, Retention: 336 * time.Hour},
+		Overrides:         map[string]config.TopicSettings{"retry_10s": {Retention: time.Hour}, "events": {Partitions: 6}},
+		ReplicationFactor: 2,
+	}
+
+	tests := []struct {
+		name string
+		kind TopicKind
+		want TopicSpec
+	}{
+		{name: "events", kind: MainTopic, want: TopicSpec{Name: "events", Partitions: 6, ReplicationFactor: 2}},
+		{name: "events_eur", kind: MainTopic, want: TopicSpec{Name: "events_eur", Partitions: 3, ReplicationFactor: 2}},
+		{name: "retry_10s", kind: RetryTopic, want: TopicSpec{Name: "retry_10s", Partitions: 1, ReplicationFactor: 2, Retention: time.Hour}},
+		{name: "retry_1m", kind: RetryTopic, want: TopicSpec{Name: "retry_1m", Partitions: 1, ReplicationFactor: 2, Retention: 24 * time.Hour}},
+		{name: "dead_letter", kind: DeadLetterTopic, want: TopicSpec{Name: "dead_letter", Partitions: 1, ReplicationFactor: 2, Retention: 336 * time.Hour}},
+	}
+
+	for _, test := range tests {
+		specs := TopicSpecs(topicsCfg, test.kind, test.name)
+		if len(specs) != 1 || !reflect.DeepEqual(specs[0], test.want) {
+			t.Errorf("got %+v for %s, want %+v", specs, test.name, test.want)
+		}
+	}
+}
+
+func TestKafkaTopicReady(t *testing.T) {
+	leader := kafka.Broker{Host: "kafka", Port: 9092, ID: 1}
+
+	tests := []struct {
+		name  string
+		topic kafka.Topic
+		want  bool
+	}{
+		{
+			name:  "leaders elected",
+			topic: kafka.Topic{Partitions: []kafka.Partition{{ID: 0, Leader: leader}, {ID: 1, Leader: leader}}},
+			want:  true,
+		},
+		{
+			name:  "leader not available",
+			topic: kafka.Topic{Partitions: []kafka.Partition{{ID: 0, Leader: leader}, {ID: 1, Error: kafka.LeaderNotAvailable}}},
+		},
+		{
+			name:  "leader unknown",
+			topic: kafka.Topic{Partitions: []kafka.Partition{{ID: 0, Leader: kafka.Broker{ID: 2}}}},
+		},
+		{
+			name:  "topic error",
+			topic: kafka.Topic{Error: kafka.LeaderNotAvailable},
+		},
+		{
+			name: "no partitions yet",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := kafkaTopicReady(test.topic); got != test.want {
+				t.Errorf("got ready %v, want %v", got, test.want)
+			}
+		})
+	}
+}
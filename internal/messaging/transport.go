@@ -0,0 +1,215 @@
+package messaging
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Bitstarz-eng/event-processing-challenge/internal/config"
+
+	"github.com/go-redis/redis/v8"
+	kafka "github.com/segmentio/kafka-go"
+)
+
+// Message is a transport-neutral record read from a Source or written to a Sink.
+type Message struct {
+	Topic         string
+	Partition     int
+	Offset        int64
+	HighWaterMark int64
+	Key           []byte
+	Value         []byte
+	Headers       Headers
+	Time          time.Time
+}
+
+type Header struct {
+	Key   string
+	Value []byte
+}
+
+// Source yields messages from a topic on behalf of a consumer group.
+type Source interface {
+	FetchMessage(ctx context.Context) (Message, error)
+	CommitMessages(ctx context.Context, msgs ...Message) error
+	Close() error
+}
+
+// WriteErrors is returned by a Sink when only some messages of a write failed.
+// It holds one entry per message, in order, which is nil for delivered ones.
+type WriteErrors []error
+
+func (e WriteErrors) Error() string {
+	failed := 0
+	for _, err := range e {
+		if err != nil {
+			failed++
+		}
+	}
+
+	return fmt.Sprintf("%d of %d messages failed", failed, len(e))
+}
+
+// first returns the first error of a failed message.
+func (e WriteErrors) first() error {
+	for _, err := range e {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Sink appends messages to a topic.
+type Sink interface {
+	WriteMessages(ctx context.Context, msgs ...Message) error
+	Close() error
+}
+
+// Transport opens sources and sinks on one messaging backend.
+type Transport interface {
+	Source(topic, group string) (Source, error)
+	Sink(topic string) (Sink, error)
+	// EnsureTopics checks that the topics exist as declared, creating the
+	// missing ones first when create is set.
+	EnsureTopics(ctx context.Context, topics []TopicSpec, create bool) error
+}
+
+// processBroker is shared by every memory transport of the process, so that
+// stages running side by side exchange events.
+var processBroker = NewBroker()
+
+// NewTransport creates the transport selected by the configuration.
+func NewTransport(transportCfg config.Transport, publishCfg config.Publish, redisCfg config.Redis) (Transport, error) {
+	switch transportCfg.Backend {
+	case "kafka":
+		return NewKafkaTransport(transportCfg.Brokers, publishCfg), nil
+	case "redis":
+		redisClient := redis.NewClient(&redis.Options{
+			Addr:     redisCfg.Addr,
+			Password: redisCfg.Password,
+			DB:       redisCfg.DB,
+		})
+		return NewRedisStreamTransport(redisClient, transportCfg.StreamConsumer, transportCfg.StreamClaimIdle, transportCfg.StreamMaxLen), nil
+	case "memory":
+		return NewMemoryTransport(processBroker), nil
+	default:
+		return nil, fmt.Errorf("unknown transport %q", transportCfg.Backend)
+	}
+}
+
+type KafkaTransport struct {
+	brokers    string
+	publishCfg config.Publish
+}
+
+func NewKafkaTransport(brokers string, publishCfg config.Publish) *KafkaTransport {
+	return &KafkaTransport{
+		brokers:    brokers,
+		publishCfg: publishCfg,
+	}
+}
+
+func (t *KafkaTransport) Source(topic, group string) (Source, error) {
+	reader := kafka.NewReader(kafka.ReaderConfig{
+		Brokers: strings.Split(t.brokers, ","),
+		Topic:   topic,
+		GroupID: group,
+	})
+
+	return NewKafkaSource(reader), nil
+}
+
+func (t *KafkaTransport) Sink(topic string) (Sink, error) {
+	writer, err := NewKafkaWriter(t.brokers, topic, t.publishCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewKafkaSink(writer), nil
+}
+
+func (t *KafkaTransport) EnsureTopics(ctx context.Context, topics []TopicSpec, create bool) error {
+	client := &kafka.Client{
+		Addr: kafka.TCP(strings.Split(t.brokers, ",")...),
+	}
+
+	return ensureKafkaTopics(ctx, client, topics, create)
+}
+
+type RedisStreamTransport struct {
+	redisClient *redis.Client
+	consumer    string
+	claimIdle   time.Duration
+	maxLen      int64
+}
+
+func NewRedisStreamTransport(redisClient *redis.Client, consumer string, claimIdle time.Duration, maxLen int64) *RedisStreamTransport {
+	return &RedisStreamTransport{
+		redisClient: redisClient,
+		consumer:    consumer,
+		claimIdle:   claimIdle,
+		maxLen:      maxLen,
+	}
+}
+
+func (t *RedisStreamTransport) Source(topic, group string) (Source, error) {
+	return NewRedisStreamSource(t.redisClient, topic, group, t.consumer, t.claimIdle), nil
+}
+
+func (t *RedisStreamTransport) Sink(topic string) (Sink, error) {
+	return NewRedisStreamSink(t.redisClient, topic, t.maxLen), nil
+}
+
+// EnsureTopics does nothing, since streams are created on first use and have
+// no partitions or replicas to declare.
+func (t *RedisStreamTransport) EnsureTopics(ctx context.Context, topics []TopicSpec, create bool) error {
+	return nil
+}
+
+// MemoryTransport opens sources and sinks on an in-process Broker.
+type MemoryTransport struct {
+	broker *Broker
+}
+
+func NewMemoryTransport(broker *Broker) *MemoryTransport {
+	return &MemoryTransport{
+		broker: broker,
+	}
+}
+
+func (t *MemoryTransport) Source(topic, group string) (Source, error) {
+	return t.broker.Source(topic, group), nil
+}
+
+func (t *MemoryTransport) Sink(topic string) (Sink, error) {
+	return t.broker.Sink(topic), nil
+}
+
+func (t *MemoryTransport) EnsureTopics(ctx context.Context, topics []TopicSpec, create bool) error {
+	var mismatches TopicMismatchError
+	for _, spec := range topics {
+		partitions, ok := t.broker.Partitions(spec.Name)
+		if !ok {
+			if !create {
+				return fmt.Errorf("missing topic: %s", spec.Name)
+			}
+
+			err := t.broker.CreateTopic(spec.Name, spec.Partitions)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		if partitions != spec.Partitions {
+			mismatches = append(mismatches, fmt.Sprintf("%s has %d partitions, want %d", spec.Name, partitions, spec.Partitions))
+		}
+	}
+
+	if len(mismatches) > 0 {
+		return mismatches
+	}
+	return nil
+}
@@ -2,108 +2,80 @@ package process
 
 import (
 	"context"
-	"encoding/json"
-	"errors"
 	"fmt"
-	"log"
-	"net/http"
 	"time"
 
 	"github.com/Bitstarz-eng/event-processing-challenge/internal/casino"
-	"github.com/Bitstarz-eng/event-processing-challenge/internal/config"
-
-	"github.com/go-redis/redis/v8"
-	"github.com/shopspring/decimal"
+	"github.com/Bitstarz-eng/event-processing-challenge/internal/exchange"
+	"github.com/Bitstarz-eng/event-processing-challenge/internal/messaging"
 )
 
 type Converter struct {
-	context     context.Context
-	redisClient *redis.Client
-	httpClient  *http.Client
+	context context.Context
+	rates   exchange.HistoricalRateProvider
+	pool    *Pool
 }
 
-func NewConverter(ctx context.Context, redisClient *redis.Client, httpClient *http.Client) *Converter {
+// NewConverter converts amounts at the rates in effect when events were
+// created, so that late and replayed events convert as they did originally.
+func NewConverter(ctx context.Context, rates exchange.HistoricalRateProvider, pool *Pool) *Converter {
 	return &Converter{
-		context:     ctx,
-		redisClient: redisClient,
-		httpClient:  httpClient,
-	}
-}
-
-func (c *Converter) Process(exchangeCfg config.Exchange, consumeCh chan casino.Event, publishCh chan casino.Event) {
-	for event := range consumeCh {
-		var err error
-		//event.AmountEUR, err = c.convertToEUR(exchangeCfg, event.Amount, event.Currency)
-		event.AmountEUR = 100
-		if err != nil {
-			log.Printf("could not convert to EUR for event %v: %v", event.ID, err)
-		}
-		publishCh <- event
+		context: ctx,
+		rates:   rates,
+		pool:    pool,
 	}
 }
 
-func (c *Converter) convertToEUR(exchangeCfg config.Exchange, amount int, currency string) (int, error) {
-	if currency == "EUR" {
-		log.Printf("Currency is EUR, no conversion needed. Amount: %d", amount)
-		return amount, nil
-	}
-
-	cacheKey := fmt.Sprintf("exchange_rate_%s_EUR", currency)
-	cachedRate, err := c.redisClient.Get(c.context, cacheKey).Result()
-	if errors.Is(err, redis.Nil) {
-		log.Printf("cache is missed for currency: %s. Fetching exchange rate from API...", currency)
-		apiURL := fmt.Sprintf("%s?access_key=%s", exchangeCfg.APIURL, exchangeCfg.APIKey)
-		resp, err := c.httpClient.Get(apiURL)
-		if err != nil {
-			return 0, fmt.Errorf("fetch exchange rate: %v", err)
-		}
-		defer resp.Body.Close()
+func (c *Converter) Process(consumeCh chan messaging.Envelope, publishCh chan messaging.Envelope) {
+	defer close(publishCh)
 
-		if resp.StatusCode != http.StatusOK {
-			return 0, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	c.pool.Run(consumeCh, func(envelope messaging.Envelope) {
+		// game_start and game_stop carry no amount to convert.
+		if !envelope.Event.HasAmount() {
+			publishCh <- envelope
+			return
 		}
 
-		var result struct {
-			Quotes map[string]float64 `json:"quotes"`
-		}
-		err = json.NewDecoder(resp.Body).Decode(&result)
+		var err error
+		envelope.Event.AmountEUR, envelope.Event.ExchangeRate, err = c.convertToEUR(envelope.Event.Amount, envelope.Event.Currency, envelope.Event.CreatedAt)
 		if err != nil {
-			return 0, fmt.Errorf("decode exchange rate response: %v", err)
-		}
-
-		quoteKey := fmt.Sprintf("%sEUR", currency)
-		rate, ok := result.Quotes[quoteKey]
-		if !ok {
-			return 0, fmt.Errorf("invalid response format, missing rate for %s", quoteKey)
+			failure := fmt.Errorf("could not convert to EUR for event %v: %v", envelope.Event.ID, err)
+			if messaging.IsPermanent(err) {
+				failure = messaging.Permanent(failure)
+			}
+			envelope.Fail(failure)
+			return
 		}
+		publishCh <- envelope
+	})
+}
 
-		log.Printf("Fetched exchange rate from API: 1 %s = %f EUR", currency, rate)
-		err = c.redisClient.Set(c.context, cacheKey, rate, time.Duration(exchangeCfg.CacheDuration)*time.Second).Err()
-		if err != nil {
-			return 0, fmt.Errorf("set cache for exchange rate: %v", err)
-		} else {
-			log.Printf("Cached exchange rate for %s: %f", currency, rate)
-		}
+// convertToEUR converts an amount in the smallest unit of currency into euro
+// cents at the rate in effect at the given time, returning the rate it used.
+func (c *Converter) convertToEUR(amount int, code string, at time.Time) (int, *casino.ExchangeRate, error) {
+	if code == "EUR" {
+		return amount, nil, nil
+	}
 
-		amountDecimal := decimal.NewFromInt(int64(amount))
-		rateDecimal := decimal.NewFromFloat(rate)
-		convertedAmount := amountDecimal.Mul(rateDecimal).IntPart()
-		log.Printf("Converted amount: %d %s = %d EUR", amount, currency, convertedAmount)
-		return int(convertedAmount), nil
-	} else if err != nil {
-		return 0, fmt.Errorf("get cached exchange rate: %v", err)
+	currency, err := casino.LookupCurrency(code)
+	if err != nil {
+		return 0, nil, messaging.Permanent(err)
+	}
+	eur, err := casino.LookupCurrency("EUR")
+	if err != nil {
+		return 0, nil, messaging.Permanent(err)
 	}
 
-	var rate float64
-	_, err = fmt.Sscanf(cachedRate, "%f", &rate)
+	quote, err := c.rates.RateAt(c.context, code, "EUR", at)
 	if err != nil {
-		return 0, fmt.Errorf("parse cached exchange rate: %v", err)
+		return 0, nil, err
 	}
-	log.Printf("Retrieved cached exchange rate: 1 %s = %f EUR", currency, rate)
 
-	amountDecimal := decimal.NewFromInt(int64(amount))
-	rateDecimal := decimal.NewFromFloat(rate)
-	convertedAmount := amountDecimal.Mul(rateDecimal).IntPart()
-	log.Printf("Converted amount using cached rate: %d %s = %d EUR", amount, currency, convertedAmount)
-	return int(convertedAmount), nil
+	rate := &casino.ExchangeRate{
+		Rate:   quote.Rate,
+		Source: quote.Source,
+		AsOf:   quote.AsOf,
+		Stale:  quote.Stale,
+	}
+	return eur.Minor(currency.Major(amount).Mul(quote.Rate)), rate, nil
 }
@@ -0,0 +1,89 @@
+package process
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Bitstarz-eng/event-processing-challenge/internal/casino"
+	"github.com/Bitstarz-eng/event-processing-challenge/internal/exchange"
+	"github.com/Bitstarz-eng/event-processing-challenge/internal/messaging"
+)
+
+// liveRates converts at live rates whatever the time.
+type liveRates struct {
+	exchange.RateProvider
+}
+
+func (r liveRates) RateAt(ctx context.Context, from, to string, t time.Time) (exchange.Quote, error) {
+	return r.Rate(ctx, from, to)
+}
+
+func TestConverterProcess(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		fmt.Fprint(w, `{"success":true,"source":"USD","quotes":{"USDEUR":0.9,"USDGBP":0.75}}`)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name         string
+		event        casino.Event
+		wantEUR      int
+		wantRate     bool
+		wantRequests int
+	}{
+		{
+			name:    "eur passes through",
+			event:   casino.Event{ID: 1, Type: "deposit", Amount: 500, Currency: "EUR"},
+			wantEUR: 500,
+		},
+		{
+			name:  "game_start is skipped",
+			event: casino.Event{ID: 2, Type: "game_start"},
+		},
+		{
+			name:  "game_stop is skipped",
+			event: casino.Event{ID: 3, Type: "game_stop"},
+		},
+		{
+			name:         "gbp is converted",
+			event:        casino.Event{ID: 4, Type: "bet", Amount: 300, Currency: "GBP"},
+			wantEUR:      360,
+			wantRate:     true,
+			wantRequests: 1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			requests = 0
+			rates := liveRates{exchange.NewExchangeRateHost(server.Client(), server.URL+"/live", "key")}
+			converter := NewConverter(context.Background(), rates, NewPool(1, 0))
+
+			consumeCh := make(chan messaging.Envelope, 1)
+			publishCh := make(chan messaging.Envelope, 1)
+			consumeCh <- messaging.NewEnvelope(test.event)
+			close(consumeCh)
+			converter.Process(consumeCh, publishCh)
+
+			envelope, ok := <-publishCh
+			if !ok {
+				t.Fatal("event was not published")
+			}
+			if envelope.Event.AmountEUR != test.wantEUR {
+				t.Errorf("got amount %d EUR, want %d", envelope.Event.AmountEUR, test.wantEUR)
+			}
+			if (envelope.Event.ExchangeRate != nil) != test.wantRate {
+				t.Errorf("got exchange rate %v, want one: %v", envelope.Event.ExchangeRate, test.wantRate)
+			}
+			if requests != test.wantRequests {
+				t.Errorf("got %d requests to the API, want %d", requests, test.wantRequests)
+			}
+		})
+	}
+}
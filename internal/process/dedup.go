@@ -0,0 +1,230 @@
+package process
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Bitstarz-eng/event-processing-challenge/internal/casino"
+	"github.com/Bitstarz-eng/event-processing-challenge/internal/config"
+	"github.com/Bitstarz-eng/event-processing-challenge/internal/messaging"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// HeaderDuplicate is set on events that were seen before when duplicates are
+// flagged rather than dropped.
+const HeaderDuplicate = "x-duplicate"
+
+// SeenSet remembers dedup keys for a limited window of time.
+type SeenSet interface {
+	Seen(ctx context.Context, key string) (bool, error)
+	// Mark marks the key as seen and reports whether it already was, in one
+	// step, so that of two replicas marking the same key only one sees it new.
+	Mark(ctx context.Context, key string) (bool, error)
+}
+
+// NewSeenSet creates the seen-set configured for the stage.
+func NewSeenSet(dedupCfg config.Dedup, redisCfg config.Redis, stage string) (SeenSet, error) {
+	switch dedupCfg.Backend {
+	case "memory":
+		return NewMemorySeenSet(dedupCfg.Window, dedupCfg.Capacity), nil
+	case "redis":
+		redisClient := redis.NewClient(&redis.Options{
+			Addr:     redisCfg.Addr,
+			Password: redisCfg.Password,
+			DB:       redisCfg.DB,
+		})
+		return NewRedisSeenSet(redisClient, "dedup:"+stage, dedupCfg.Window), nil
+	default:
+		return nil, fmt.Errorf("unknown dedup backend %q", dedupCfg.Backend)
+	}
+}
+
+// Deduplicator filters out events whose ID has already gone through the stage.
+// Replayed events are told apart from the originals and from other replays
+// by their replay header, so that each replay goes through every stage once.
+type Deduplicator struct {
+	ctx            context.Context
+	seen           SeenSet
+	flag           bool
+	markOnDelivery bool
+	duplicates     int64
+}
+
+// NewDeduplicator drops duplicates, or passes them on with HeaderDuplicate set
+// if flag is true. With markOnDelivery, an event only counts as seen once
+// Delivered reports it as published, so that events lost to a crash before
+// publishing are not mistaken for duplicates when they are delivered again.
+// The price is that copies of an event handled at the same time can both get
+// through, which marking on arrival rules out.
+func NewDeduplicator(ctx context.Context, seen SeenSet, flag bool, markOnDelivery bool) *Deduplicator {
+	return &Deduplicator{
+		ctx:            ctx,
+		seen:           seen,
+		flag:           flag,
+		markOnDelivery: markOnDelivery,
+	}
+}
+
+func (d *Deduplicator) Process(consumeCh <-chan messaging.Envelope, publishCh chan<- messaging.Envelope) {
+	defer close(publishCh)
+
+	for envelope := range consumeCh {
+		key := dedupKey(envelope.Event, envelope.Headers)
+		var seen bool
+		var err error
+		if d.markOnDelivery {
+			seen, err = d.seen.Seen(d.ctx, key)
+		} else {
+			seen, err = d.seen.Mark(d.ctx, key)
+		}
+		if err != nil {
+			// Better to let a duplicate through than to hold up the stage.
+			log.Printf("error checking event %d for duplicates: %v", envelope.Event.ID, err)
+		}
+
+		if seen {
+			count := atomic.AddInt64(&d.duplicates, 1)
+			log.Printf("Duplicate event ID %d (%d duplicates so far)", envelope.Event.ID, count)
+			if !d.flag {
+				envelope.Ack()
+				continue
+			}
+			envelope.Headers = envelope.Headers.Without(HeaderDuplicate)
+			envelope.Headers.Add(HeaderDuplicate, "true")
+		}
+
+		publishCh <- envelope
+	}
+}
+
+// Delivered marks published events as seen. It is meant to be passed to
+// messaging.WithDeliveryReports.
+func (d *Deduplicator) Delivered(delivery messaging.Delivery) {
+	if delivery.Err != nil || !d.markOnDelivery {
+		return
+	}
+
+	key := dedupKey(delivery.Event, delivery.Headers)
+	_, err := d.seen.Mark(d.ctx, key)
+	if err != nil {
+		log.Printf("error marking %s as seen: %v", key, err)
+	}
+}
+
+// Duplicates counts the duplicates seen so far, whether dropped or flagged.
+func (d *Deduplicator) Duplicates() int64 {
+	return atomic.LoadInt64(&d.duplicates)
+}
+
+// dedupKey is the event ID, followed by the replay header for replayed events.
+func dedupKey(event casino.Event, headers messaging.Headers) string {
+	replay, ok := headers.Get(messaging.HeaderReplay)
+	if !ok {
+		return strconv.Itoa(event.ID)
+	}
+	return fmt.Sprintf("%d@%s", event.ID, replay)
+}
+
+// IsDuplicate reports whether an earlier stage flagged the event as a duplicate.
+func IsDuplicate(envelope messaging.Envelope) bool {
+	_, ok := envelope.Headers.Get(HeaderDuplicate)
+	return ok
+}
+
+// MemorySeenSet keeps up to capacity keys for the given window.
+type MemorySeenSet struct {
+	mu       sync.Mutex
+	window   time.Duration
+	capacity int
+	seenAt   map[string]time.Time
+	order    []seenEntry
+}
+
+type seenEntry struct {
+	key    string
+	seenAt time.Time
+}
+
+func NewMemorySeenSet(window time.Duration, capacity int) *MemorySeenSet {
+	return &MemorySeenSet{
+		window:   window,
+		capacity: capacity,
+		seenAt:   make(map[string]time.Time),
+	}
+}
+
+func (s *MemorySeenSet) Seen(ctx context.Context, key string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.seen(key, time.Now()), nil
+}
+
+func (s *MemorySeenSet) Mark(ctx context.Context, key string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if s.seen(key, now) {
+		return true, nil
+	}
+	s.seenAt[key] = now
+	s.order = append(s.order, seenEntry{key: key, seenAt: now})
+
+	for len(s.order) > 0 && (len(s.order) > s.capacity || now.Sub(s.order[0].seenAt) >= s.window) {
+		oldest := s.order[0]
+		// A later mark of the same key has its own entry further back.
+		if s.seenAt[oldest.key].Equal(oldest.seenAt) {
+			delete(s.seenAt, oldest.key)
+		}
+		s.order = s.order[1:]
+	}
+
+	return false, nil
+}
+
+// seen must be called with s.mu held.
+func (s *MemorySeenSet) seen(key string, now time.Time) bool {
+	seenAt, ok := s.seenAt[key]
+	return ok && now.Sub(seenAt) < s.window
+}
+
+// RedisSeenSet shares seen keys between replicas of a stage, letting
+// Redis expire them after the window.
+type RedisSeenSet struct {
+	redisClient *redis.Client
+	prefix      string
+	window      time.Duration
+}
+
+func NewRedisSeenSet(redisClient *redis.Client, prefix string, window time.Duration) *RedisSeenSet {
+	return &RedisSeenSet{
+		redisClient: redisClient,
+		prefix:      prefix,
+		window:      window,
+	}
+}
+
+func (s *RedisSeenSet) Seen(ctx context.Context, key string) (bool, error) {
+	n, err := s.redisClient.Exists(ctx, s.prefix+":"+key).Result()
+	if err != nil {
+		return false, fmt.Errorf("check seen event: %v", err)
+	}
+
+	return n > 0, nil
+}
+
+func (s *RedisSeenSet) Mark(ctx context.Context, key string) (bool, error) {
+	set, err := s.redisClient.SetNX(ctx, s.prefix+":"+key, 1, s.window).Result()
+	if err != nil {
+		return false, fmt.Errorf("mark seen event: %v", err)
+	}
+
+	return !set, nil
+}
@@ -0,0 +1,60 @@
+package process
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Bitstarz-eng/event-processing-challenge/internal/casino"
+	"github.com/Bitstarz-eng/event-processing-challenge/internal/messaging"
+)
+
+func TestDeduplicatorReplays(t *testing.T) {
+	replay := func(source string) messaging.Headers {
+		var headers messaging.Headers
+		headers.Add(messaging.HeaderReplay, source)
+		return headers
+	}
+
+	envelopes := []struct {
+		name    string
+		headers messaging.Headers
+		want    bool
+	}{
+		{name: "original", want: true},
+		{name: "duplicate of original", want: false},
+		{name: "replay", headers: replay("casino_events/0/7"), want: true},
+		{name: "duplicate of replay", headers: replay("casino_events/0/7"), want: false},
+		{name: "replay from another message", headers: replay("casino_events/1/3"), want: true},
+	}
+
+	deduplicator := NewDeduplicator(context.Background(), NewMemorySeenSet(time.Minute, 100), false, false)
+	consumeCh := make(chan messaging.Envelope, len(envelopes))
+	publishCh := make(chan messaging.Envelope, len(envelopes))
+	for _, envelope := range envelopes {
+		consumeCh <- messaging.Envelope{Event: casino.Event{ID: 1}, Headers: envelope.headers}
+	}
+	close(consumeCh)
+	deduplicator.Process(consumeCh, publishCh)
+
+	for _, envelope := range envelopes {
+		if !envelope.want {
+			continue
+		}
+		published, ok := <-publishCh
+		if !ok {
+			t.Fatalf("%s was not published", envelope.name)
+		}
+		got, _ := published.Headers.Get(messaging.HeaderReplay)
+		want, _ := envelope.headers.Get(messaging.HeaderReplay)
+		if got != want {
+			t.Errorf("published replay %q, want %s with replay %q", got, envelope.name, want)
+		}
+	}
+	if published, ok := <-publishCh; ok {
+		t.Errorf("published unexpected event with headers %v", published.Headers)
+	}
+	if n := deduplicator.Duplicates(); n != 2 {
+		t.Errorf("counted %d duplicates, want 2", n)
+	}
+}
@@ -5,30 +5,33 @@ import (
 	"fmt"
 
 	"github.com/Bitstarz-eng/event-processing-challenge/internal/casino"
-
-	"github.com/shopspring/decimal"
+	"github.com/Bitstarz-eng/event-processing-challenge/internal/messaging"
 )
 
 const unknownGame = "Unknown Game"
 const createdAtFormat = "January 2nd, 2006 at 15:04 UTC"
 
 type Descriptor struct {
-	ctx context.Context
+	ctx  context.Context
+	pool *Pool
 }
 
-func NewDescriptor(ctx context.Context) *Descriptor {
+func NewDescriptor(ctx context.Context, pool *Pool) *Descriptor {
 	return &Descriptor{
-		ctx: ctx,
+		ctx:  ctx,
+		pool: pool,
 	}
 }
 
-func (d *Descriptor) Process(consumeCh <-chan casino.Event, publishCh chan<- casino.Event) {
-	for event := range consumeCh {
-		description := d.createDescription(event)
-		event.Description = description
+func (d *Descriptor) Process(consumeCh <-chan messaging.Envelope, publishCh chan<- messaging.Envelope) {
+	defer close(publishCh)
 
-		publishCh <- event
-	}
+	d.pool.Run(consumeCh, func(envelope messaging.Envelope) {
+		description := d.createDescription(envelope.Event)
+		envelope.Event.Description = description
+
+		publishCh <- envelope
+	})
 }
 
 func (d *Descriptor) createDescription(event casino.Event) string {
@@ -36,23 +39,23 @@ func (d *Descriptor) createDescription(event casino.Event) string {
 	createdAt := event.CreatedAt.Format(createdAtFormat)
 	description := ""
 
-	amount := decimal.NewFromInt(int64(event.Amount)).Div(decimal.NewFromInt(100))
-	amountEUR := decimal.NewFromInt(int64(event.AmountEUR)).Div(decimal.NewFromInt(100))
+	amount := formatAmount(event.Amount, event.Currency)
+	amountEUR := formatAmount(event.AmountEUR, "EUR")
 
 	switch event.Type {
 	case "game_start":
 		description = fmt.Sprintf("Player #%d started playing a game \"%s\" on %s.", event.PlayerID, gameTitle, createdAt)
 	case "bet":
-		description = fmt.Sprintf("Player #%d (%s) placed a bet of %s %s (%s EUR) on a game \"%s\" on %s.",
-			event.PlayerID, event.Player.Email, amount.StringFixed(2), event.Currency, amountEUR.StringFixed(2), gameTitle, createdAt)
+		description = fmt.Sprintf("Player #%d (%s) placed a bet of %s (%s) on a game \"%s\" on %s.",
+			event.PlayerID, event.Player.Email, amount, amountEUR, gameTitle, createdAt)
 		if event.HasWon {
 			description += " The bet was won."
 		} else {
 			description += " The bet was lost."
 		}
 	case "deposit":
-		description = fmt.Sprintf("Player #%d made a deposit of %s %s on %s.",
-			event.PlayerID, amount.StringFixed(2), event.Currency, createdAt)
+		description = fmt.Sprintf("Player #%d made a deposit of %s on %s.",
+			event.PlayerID, amount, createdAt)
 	case "game_stop":
 		description = fmt.Sprintf("Player #%d stopped playing a game \"%s\" on %s.", event.PlayerID, gameTitle, createdAt)
 	default:
@@ -62,6 +65,17 @@ func (d *Descriptor) createDescription(event casino.Event) string {
 	return description
 }
 
+// formatAmount displays an amount in the smallest unit of a currency, showing
+// it as it is when the currency is unknown.
+func formatAmount(amount int, code string) string {
+	currency, err := casino.LookupCurrency(code)
+	if err != nil {
+		return fmt.Sprintf("%d %s", amount, code)
+	}
+
+	return currency.Format(amount)
+}
+
 func getGameTitle(gameID int) string {
 	game, ok := casino.Games[gameID]
 	if !ok {
@@ -4,7 +4,7 @@ import (
 	"encoding/json"
 	"log"
 
-	"github.com/Bitstarz-eng/event-processing-challenge/internal/casino"
+	"github.com/Bitstarz-eng/event-processing-challenge/internal/messaging"
 )
 
 type Logger struct{}
@@ -13,13 +13,15 @@ func NewLogger() *Logger {
 	return &Logger{}
 }
 
-func (l *Logger) Process(logCh <-chan casino.Event) {
-	for event := range logCh {
-		logJSON, err := json.Marshal(event)
+func (l *Logger) Process(logCh <-chan messaging.Envelope) {
+	for envelope := range logCh {
+		logJSON, err := json.Marshal(envelope.Event)
 		if err != nil {
 			log.Printf("error marshaling event to JSON: %v", err)
+			envelope.Ack()
 			continue
 		}
 		log.Println(string(logJSON))
+		envelope.Ack()
 	}
 }
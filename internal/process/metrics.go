@@ -8,7 +8,7 @@ import (
 	"sync"
 	"time"
 
-	"github.com/Bitstarz-eng/event-processing-challenge/internal/casino"
+	"github.com/Bitstarz-eng/event-processing-challenge/internal/messaging"
 
 	"github.com/shopspring/decimal"
 )
@@ -51,8 +51,16 @@ type DepositMetric struct {
 	AmountEUR int `json:"amount_eur"`
 }
 
-func (m *Metrics) Process(consumeCh <-chan casino.Event, resultCh chan<- casino.Event) {
-	for event := range consumeCh {
+func (m *Metrics) Process(consumeCh <-chan messaging.Envelope, resultCh chan<- messaging.Envelope) {
+	defer close(resultCh)
+
+	for envelope := range consumeCh {
+		if IsDuplicate(envelope) {
+			resultCh <- envelope
+			continue
+		}
+
+		event := envelope.Event
 		m.mu.Lock()
 		m.TotalEvents++
 
@@ -89,7 +97,9 @@ func (m *Metrics) Process(consumeCh <-chan casino.Event, resultCh chan<- casino.
 		// Calculate events per second moving average in the last minute
 		if len(m.eventTimestamps) > 0 {
 			durationCovered := decimal.NewFromInt(time.Now().Unix() - m.eventTimestamps[0])
-			m.EventsPerSecondMovingAvg = decimal.NewFromInt(int64(len(m.eventTimestamps))).Div(durationCovered)
+			if durationCovered.IsPositive() {
+				m.EventsPerSecondMovingAvg = decimal.NewFromInt(int64(len(m.eventTimestamps))).Div(durationCovered)
+			}
 		}
 
 		// Determine top players
@@ -102,7 +112,7 @@ func (m *Metrics) Process(consumeCh <-chan casino.Event, resultCh chan<- casino.
 
 		m.mu.Unlock()
 
-		resultCh <- event
+		resultCh <- envelope
 	}
 }
 
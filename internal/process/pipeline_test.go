@@ -0,0 +1,281 @@
+package process
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Bitstarz-eng/event-processing-challenge/internal/casino"
+	"github.com/Bitstarz-eng/event-processing-challenge/internal/config"
+	"github.com/Bitstarz-eng/event-processing-challenge/internal/exchange"
+	"github.com/Bitstarz-eng/event-processing-challenge/internal/generator"
+	"github.com/Bitstarz-eng/event-processing-challenge/internal/messaging"
+
+	"github.com/shopspring/decimal"
+)
+
+const pipelineEvents = 20
+
+var signedInAt = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+// fixedRates converts every currency at the same rate.
+type fixedRates struct{}
+
+func (fixedRates) RateAt(ctx context.Context, from, to string, t time.Time) (exchange.Quote, error) {
+	return exchange.Quote{Rate: decimal.NewFromInt(2), Source: "test", AsOf: t}, nil
+}
+
+func init() {
+	sql.Register("players", playersDriver{})
+}
+
+func TestPipelineInProcess(t *testing.T) {
+	db, err := sql.Open("players", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	transport, err := messaging.NewTransport(config.Transport{Backend: "memory"}, config.Publish{}, config.Redis{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = transport.EnsureTopics(context.Background(), messaging.TopicSpecs(config.Topics{Main: config.TopicSettings{Partitions: 3}}, messaging.MainTopic, "events", "events_eur", "events_player", "events_described"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var stages []*pipelineStage
+	defer func() {
+		for _, stage := range stages {
+			stage.stop()
+		}
+	}()
+
+	generatorStage := startPipelineStage(t, ctx, transport, "generator", "", "events", nil)
+	stages = append(stages, generatorStage)
+	go func() {
+		defer close(generatorStage.results)
+
+		generateCtx, stopGenerating := context.WithCancel(ctx)
+		defer stopGenerating()
+		n := 0
+		for event := range generator.Generate(generateCtx) {
+			generatorStage.results <- messaging.NewEnvelope(event)
+			n++
+			if n == pipelineEvents {
+				return
+			}
+		}
+	}()
+
+	converter := NewConverter(ctx, fixedRates{}, NewPool(2, 0))
+	stages = append(stages, startPipelineStage(t, ctx, transport, "currency", "events", "events_eur", converter.Process))
+
+	player := NewPlayerData(ctx, db, NewPool(2, 0))
+	stages = append(stages, startPipelineStage(t, ctx, transport, "player", "events_eur", "events_player", player.Process))
+
+	descriptor := NewDescriptor(ctx, NewPool(2, 0))
+	stages = append(stages, startPipelineStage(t, ctx, transport, "description", "events_player", "events_described", func(consumeCh chan messaging.Envelope, publishCh chan messaging.Envelope) {
+		descriptor.Process(consumeCh, publishCh)
+	}))
+
+	metrics := NewMetrics()
+	materializeStage := startPipelineStage(t, ctx, transport, "materialize", "events_described", "", func(consumeCh chan messaging.Envelope, resultCh chan messaging.Envelope) {
+		metrics.Process(consumeCh, resultCh)
+	})
+	stages = append(stages, materializeStage)
+
+	seen := make(map[int]bool)
+	for len(seen) < pipelineEvents {
+		var envelope messaging.Envelope
+		select {
+		case envelope = <-materializeStage.results:
+		case <-ctx.Done():
+			t.Fatalf("materialized %d of %d events", len(seen), pipelineEvents)
+		}
+		envelope.Ack()
+
+		event := envelope.Event
+		if seen[event.ID] {
+			t.Errorf("event %d was materialized twice", event.ID)
+		}
+		seen[event.ID] = true
+
+		if event.Description == "" {
+			t.Errorf("event %d has no description", event.ID)
+		}
+		if event.HasAmount() && event.Currency != "EUR" {
+			currency, err := casino.LookupCurrency(event.Currency)
+			if err != nil {
+				t.Fatal(err)
+			}
+			eur, _ := casino.LookupCurrency("EUR")
+			want := eur.Minor(currency.Major(event.Amount).Mul(decimal.NewFromInt(2)))
+			if event.AmountEUR != want {
+				t.Errorf("event %d converted %d %s to %d EUR, want %d", event.ID, event.Amount, event.Currency, event.AmountEUR, want)
+			}
+		}
+		if _, ok := players[int64(event.PlayerID)]; ok && event.Player.Email == "" {
+			t.Errorf("event %d of player %d has no player data", event.ID, event.PlayerID)
+		}
+	}
+
+	metrics.mu.RLock()
+	defer metrics.mu.RUnlock()
+	if metrics.TotalEvents != pipelineEvents {
+		t.Errorf("got %d events in the metrics, want %d", metrics.TotalEvents, pipelineEvents)
+	}
+}
+
+// pipelineStage runs a stage the way its main does: a consumer of the input
+// topic feeds the processor, whose results are published to the output topic.
+// Without an input topic whatever is sent on results is published, and
+// without an output topic the results are left to be read from results.
+type pipelineStage struct {
+	consumer  *messaging.Consumer
+	results   chan messaging.Envelope
+	published bool
+	done      sync.WaitGroup
+}
+
+func startPipelineStage(t *testing.T, ctx context.Context, transport messaging.Transport, name, input, output string, process func(chan messaging.Envelope, chan messaging.Envelope)) *pipelineStage {
+	stage := &pipelineStage{
+		results:   make(chan messaging.Envelope),
+		published: output != "",
+	}
+
+	if stage.published {
+		sink, err := transport.Sink(output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		publisher := messaging.NewPublisher(ctx, sink, stage.results, messaging.WithStage(name), messaging.WithBatching(5, time.Millisecond))
+		stage.done.Add(1)
+		go func() {
+			defer stage.done.Done()
+			publisher.Publish()
+		}()
+	}
+
+	if input == "" {
+		return stage
+	}
+
+	source, err := transport.Source(input, name+"-group")
+	if err != nil {
+		t.Fatal(err)
+	}
+	consumeCh := make(chan messaging.Envelope)
+	stage.consumer = messaging.NewConsumer(ctx, source, consumeCh)
+	go func() {
+		defer close(consumeCh)
+		stage.consumer.Consume()
+	}()
+
+	stage.done.Add(1)
+	go func() {
+		defer stage.done.Done()
+		process(consumeCh, stage.results)
+	}()
+
+	return stage
+}
+
+func (s *pipelineStage) stop() {
+	if !s.published {
+		go func() {
+			for range s.results {
+			}
+		}()
+	}
+	if s.consumer != nil {
+		s.consumer.Stop()
+	}
+	s.done.Wait()
+	if s.consumer != nil {
+		s.consumer.Close()
+	}
+}
+
+var players = map[int64]string{
+	10: "john@example.com",
+	11: "jane@example.com",
+	12: "bob@example.com",
+}
+
+// playersDriver answers the player query of PlayerData from the players map.
+type playersDriver struct{}
+
+func (playersDriver) Open(name string) (driver.Conn, error) {
+	return playersConn{}, nil
+}
+
+type playersConn struct{}
+
+func (playersConn) Prepare(query string) (driver.Stmt, error) {
+	if !strings.Contains(query, "FROM players") {
+		return nil, errors.New("unexpected query: " + query)
+	}
+	return playersStmt{}, nil
+}
+
+func (playersConn) Close() error {
+	return nil
+}
+
+func (playersConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("transactions are not supported")
+}
+
+type playersStmt struct{}
+
+func (playersStmt) Close() error {
+	return nil
+}
+
+func (playersStmt) NumInput() int {
+	return 1
+}
+
+func (playersStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return nil, errors.New("exec is not supported")
+}
+
+func (playersStmt) Query(args []driver.Value) (driver.Rows, error) {
+	rows := &playersRows{}
+	if email, ok := players[args[0].(int64)]; ok {
+		rows.values = [][]driver.Value{{email, signedInAt}}
+	}
+	return rows, nil
+}
+
+type playersRows struct {
+	values [][]driver.Value
+}
+
+func (r *playersRows) Columns() []string {
+	return []string{"email", "last_signed_in_at"}
+}
+
+func (r *playersRows) Close() error {
+	return nil
+}
+
+func (r *playersRows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
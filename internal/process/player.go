@@ -8,35 +8,41 @@ import (
 	"log"
 
 	"github.com/Bitstarz-eng/event-processing-challenge/internal/casino"
+	"github.com/Bitstarz-eng/event-processing-challenge/internal/messaging"
 )
 
 type PlayerData struct {
-	ctx context.Context
-	db  *sql.DB
+	ctx  context.Context
+	db   *sql.DB
+	pool *Pool
 }
 
-func NewPlayerData(ctx context.Context, db *sql.DB) *PlayerData {
+func NewPlayerData(ctx context.Context, db *sql.DB, pool *Pool) *PlayerData {
 	return &PlayerData{
-		ctx: ctx,
-		db:  db,
+		ctx:  ctx,
+		db:   db,
+		pool: pool,
 	}
 }
 
-func (p *PlayerData) Process(consumeCh chan casino.Event, publishCh chan casino.Event) {
-	for event := range consumeCh {
-		player, err := p.getPlayerData(event.PlayerID)
+func (p *PlayerData) Process(consumeCh chan messaging.Envelope, publishCh chan messaging.Envelope) {
+	defer close(publishCh)
+
+	p.pool.Run(consumeCh, func(envelope messaging.Envelope) {
+		player, err := p.getPlayerData(envelope.Event.PlayerID)
 		if err != nil {
-			log.Printf("error fetching player data: %v", err)
+			envelope.Fail(fmt.Errorf("error fetching player data: %v", err))
+			return
+		}
+
+		if player == nil || player.IsZero() {
+			log.Printf("Player data missing ID: %v", envelope.Event.PlayerID)
 		} else {
-			if player == nil || player.IsZero() {
-				log.Printf("Player data missing ID: %v", event.PlayerID)
-			} else {
-				event.Player = *player
-			}
+			envelope.Event.Player = *player
 		}
 
-		publishCh <- event
-	}
+		publishCh <- envelope
+	})
 }
 
 func (p *PlayerData) getPlayerData(playerID int) (*casino.Player, error) {
@@ -55,3 +61,21 @@ func (p *PlayerData) getPlayerData(playerID int) (*casino.Player, error) {
 
 	return &player, nil
 }
+
+// WritePlayerEvent records an enriched event in player_events.
+func WritePlayerEvent(ctx context.Context, tx *sql.Tx, envelope messaging.Envelope) error {
+	event := envelope.Event
+
+	var email sql.NullString
+	if event.Player.Email != "" {
+		email = sql.NullString{String: event.Player.Email, Valid: true}
+	}
+
+	query := `INSERT INTO player_events (event_id, player_id, type, email, created_at) VALUES ($1, $2, $3, $4, $5)`
+	_, err := tx.ExecContext(ctx, query, event.ID, event.PlayerID, event.Type, email, event.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("writing player event: %v", err)
+	}
+
+	return nil
+}
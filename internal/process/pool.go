@@ -0,0 +1,66 @@
+package process
+
+import (
+	"sync"
+
+	"github.com/Bitstarz-eng/event-processing-challenge/internal/messaging"
+)
+
+// Pool runs a stage's work on several goroutines at once. Events of the same
+// player always go to the same worker, so they are handled in the order they
+// were consumed.
+type Pool struct {
+	workers int
+	buffer  int
+}
+
+// NewPool creates a pool of workers, each queueing at most buffer events, so
+// that a slow worker eventually holds up consumption instead of piling up.
+func NewPool(workers, buffer int) *Pool {
+	if workers < 1 {
+		workers = 1
+	}
+	if buffer < 0 {
+		buffer = 0
+	}
+
+	return &Pool{
+		workers: workers,
+		buffer:  buffer,
+	}
+}
+
+// Run hands every envelope from consumeCh to handle and returns once
+// consumeCh is closed and all envelopes have been handled.
+func (p *Pool) Run(consumeCh <-chan messaging.Envelope, handle func(messaging.Envelope)) {
+	var wg sync.WaitGroup
+	queues := make([]chan messaging.Envelope, p.workers)
+	for i := range queues {
+		queues[i] = make(chan messaging.Envelope, p.buffer)
+
+		wg.Add(1)
+		go func(queue <-chan messaging.Envelope) {
+			defer wg.Done()
+			for envelope := range queue {
+				handle(envelope)
+			}
+		}(queues[i])
+	}
+
+	for envelope := range consumeCh {
+		queues[p.worker(envelope.Event.PlayerID)] <- envelope
+	}
+
+	for _, queue := range queues {
+		close(queue)
+	}
+	wg.Wait()
+}
+
+func (p *Pool) worker(playerID int) int {
+	idx := playerID % p.workers
+	if idx < 0 {
+		idx += p.workers
+	}
+	return idx
+}
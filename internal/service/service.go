@@ -0,0 +1,191 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/Bitstarz-eng/event-processing-challenge/internal/messaging"
+)
+
+// Service runs the steps of a pipeline service until they finish on their own
+// or a shutdown signal arrives. On shutdown it stops fetching, lets in-flight
+// events drain through the steps, commits what was handled, stops its HTTP
+// servers and closes its resources, giving up once the shutdown timeout has
+// passed.
+type Service struct {
+	name            string
+	shutdownTimeout time.Duration
+
+	ctx      context.Context
+	cancel   context.CancelFunc
+	stopping context.Context
+	stop     context.CancelFunc
+
+	steps     sync.WaitGroup
+	consumers []*messaging.Consumer
+	servers   []*http.Server
+	closers   []io.Closer
+	reports   map[string]func() interface{}
+	failed    chan struct{}
+	failOnce  sync.Once
+}
+
+func New(name string, shutdownTimeout time.Duration) *Service {
+	ctx, cancel := context.WithCancel(context.Background())
+	stopping, stop := context.WithCancel(ctx)
+
+	return &Service{
+		name:            name,
+		shutdownTimeout: shutdownTimeout,
+		ctx:             ctx,
+		cancel:          cancel,
+		stopping:        stopping,
+		stop:            stop,
+		reports:         make(map[string]func() interface{}),
+		failed:          make(chan struct{}),
+	}
+}
+
+// Context is cancelled once the service has drained, or when draining takes
+// longer than the shutdown timeout. Steps use it for the work they do on
+// events, so that in-flight events can still be handled while shutting down.
+func (s *Service) Context() context.Context {
+	return s.ctx
+}
+
+// Stopping is cancelled as soon as shutdown begins. Steps that produce events
+// of their own use it to know when to stop.
+func (s *Service) Stopping() context.Context {
+	return s.stopping
+}
+
+// Go runs a step of the pipeline. Shutdown waits for all steps to return, so
+// each step must return once its input channel is closed.
+func (s *Service) Go(step func()) {
+	s.steps.Add(1)
+	go func() {
+		defer s.steps.Done()
+		step()
+	}()
+}
+
+// Consume runs the consumers, which all feed eventCh, and closes eventCh once
+// they have stopped so that the steps downstream drain and return.
+func (s *Service) Consume(eventCh chan<- messaging.Envelope, consumers ...*messaging.Consumer) {
+	var running sync.WaitGroup
+	for _, consumer := range consumers {
+		s.consumers = append(s.consumers, consumer)
+
+		running.Add(1)
+		consumer := consumer
+		s.Go(func() {
+			defer running.Done()
+			consumer.Consume()
+		})
+	}
+
+	s.Go(func() {
+		running.Wait()
+		close(eventCh)
+	})
+}
+
+// Serve runs the HTTP server until the service has drained. If the server
+// fails, the service shuts down.
+func (s *Service) Serve(server *http.Server) {
+	s.servers = append(s.servers, server)
+
+	go func() {
+		err := server.ListenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("HTTP server on %s failed: %v", server.Addr, err)
+			s.failOnce.Do(func() { close(s.failed) })
+		}
+	}()
+}
+
+// OnClose registers a resource to close once the service has drained.
+// Resources are closed in reverse order of registration.
+func (s *Service) OnClose(closer io.Closer) {
+	s.closers = append(s.closers, closer)
+}
+
+// Run blocks until the service has shut down.
+func (s *Service) Run() {
+	signalCh := make(chan os.Signal, 1)
+	signal.Notify(signalCh, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signalCh)
+
+	stepsDone := make(chan struct{})
+	go func() {
+		s.steps.Wait()
+		close(stepsDone)
+	}()
+
+	select {
+	case sig := <-signalCh:
+		log.Printf("Received %v, draining %s service...", sig, s.name)
+	case <-s.failed:
+		log.Printf("Draining %s service after failure...", s.name)
+	case <-stepsDone:
+	}
+
+	s.shutdown(stepsDone)
+	log.Printf("%s service stopped", s.name)
+}
+
+func (s *Service) shutdown(stepsDone <-chan struct{}) {
+	deadline := time.Now().Add(s.shutdownTimeout)
+
+	s.stop()
+	for _, consumer := range s.consumers {
+		consumer.Stop()
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		<-stepsDone
+		for _, consumer := range s.consumers {
+			err := consumer.Close()
+			if err != nil {
+				log.Printf("error closing consumer: %v", err)
+			}
+		}
+		close(drained)
+	}()
+
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+
+	select {
+	case <-drained:
+	case <-timer.C:
+		log.Printf("%s service did not drain within %s, events still in flight will be delivered again", s.name, s.shutdownTimeout)
+	}
+	s.cancel()
+
+	shutdownCtx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+	for _, server := range s.servers {
+		err := server.Shutdown(shutdownCtx)
+		if err != nil {
+			log.Printf("error shutting down HTTP server on %s: %v", server.Addr, err)
+			server.Close()
+		}
+	}
+
+	for i := len(s.closers) - 1; i >= 0; i-- {
+		err := s.closers[i].Close()
+		if err != nil {
+			log.Printf("error closing: %v", err)
+		}
+	}
+}
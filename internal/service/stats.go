@@ -0,0 +1,146 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Bitstarz-eng/event-processing-challenge/internal/messaging"
+)
+
+// Stats describes how far the consumers of a service are behind, along with
+// whatever else the service reports.
+type Stats struct {
+	Service           string                    `json:"service"`
+	Lag               int64                     `json:"lag"`
+	MessagesPerSecond float64                   `json:"messages_per_second"`
+	Consumers         []messaging.ConsumerStats `json:"consumers"`
+	Reports           map[string]interface{}    `json:"reports,omitempty"`
+}
+
+func (s *Service) Stats() Stats {
+	stats := Stats{
+		Service:   s.name,
+		Consumers: make([]messaging.ConsumerStats, 0, len(s.consumers)),
+	}
+	for _, consumer := range s.consumers {
+		consumerStats := consumer.Stats()
+		stats.Lag += consumerStats.Lag
+		stats.MessagesPerSecond += consumerStats.MessagesPerSecond
+		stats.Consumers = append(stats.Consumers, consumerStats)
+	}
+
+	if len(s.reports) > 0 {
+		stats.Reports = make(map[string]interface{}, len(s.reports))
+		for name, report := range s.reports {
+			stats.Reports[name] = report()
+		}
+	}
+
+	return stats
+}
+
+// Report adds what report returns to the stats of the service under name.
+func (s *Service) Report(name string, report func() interface{}) {
+	s.reports[name] = report
+}
+
+func (s *Service) GetStats(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, s.Stats())
+}
+
+// ServeStats serves the stats of the service on addr under /stats.
+func (s *Service) ServeStats(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stats", s.GetStats)
+	s.Serve(&http.Server{Addr: addr, Handler: mux})
+}
+
+// PipelineStats combines the stats of a service with those its peers serve,
+// giving one view of every stage of the pipeline.
+type PipelineStats struct {
+	service    *Service
+	peers      []string
+	httpClient *http.Client
+}
+
+func NewPipelineStats(service *Service, peers []string) *PipelineStats {
+	return &PipelineStats{
+		service: service,
+		peers:   peers,
+		httpClient: &http.Client{
+			Timeout: 2 * time.Second,
+		},
+	}
+}
+
+func (p *PipelineStats) GetStats(w http.ResponseWriter, r *http.Request) {
+	stats := make([]Stats, len(p.peers))
+	errs := make([]error, len(p.peers))
+
+	var wg sync.WaitGroup
+	for i, peer := range p.peers {
+		wg.Add(1)
+		go func(i int, peer string) {
+			defer wg.Done()
+			stats[i], errs[i] = p.fetch(peer)
+		}(i, peer)
+	}
+	wg.Wait()
+
+	response := struct {
+		Stages []Stats           `json:"stages"`
+		Errors map[string]string `json:"errors,omitempty"`
+	}{
+		Stages: make([]Stats, 0, len(p.peers)+1),
+	}
+	for i, peer := range p.peers {
+		if errs[i] != nil {
+			log.Printf("error fetching stats from %s: %v", peer, errs[i])
+			if response.Errors == nil {
+				response.Errors = make(map[string]string)
+			}
+			response.Errors[peer] = errs[i].Error()
+			continue
+		}
+		response.Stages = append(response.Stages, stats[i])
+	}
+	response.Stages = append(response.Stages, p.service.Stats())
+
+	writeJSON(w, response)
+}
+
+func (p *PipelineStats) fetch(url string) (Stats, error) {
+	resp, err := p.httpClient.Get(url)
+	if err != nil {
+		return Stats{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return Stats{}, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	var stats Stats
+	err = json.NewDecoder(resp.Body).Decode(&stats)
+	if err != nil {
+		return Stats{}, fmt.Errorf("decode stats: %v", err)
+	}
+
+	return stats, nil
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("Failed to encode stats: %v", err)
+		http.Error(w, "stats error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}